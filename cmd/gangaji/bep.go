@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildEvents holds what gangaji uses from a Build Event Protocol JSON file
+// (bazel --build_event_json_file)
+type BuildEvents struct {
+	Command           string          `json:"command,omitempty"`
+	CommandLine       []string        `json:"commandLine,omitempty"`
+	Options           []BuildOption   `json:"options,omitempty"`
+	Targets           []TargetOutcome `json:"targets,omitempty"`
+	Tests             []TestResult    `json:"tests,omitempty"`
+	TestSummaries     []TargetOutcome `json:"testSummaries,omitempty"`
+	ActionCacheHits   int64           `json:"actionCacheHits"`
+	ActionCacheMisses int64           `json:"actionCacheMisses"`
+}
+
+// BuildOption is one option from the canonical command line
+type BuildOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TargetOutcome is the final status of a configured target or test
+type TargetOutcome struct {
+	Target string `json:"target"`
+	Status string `json:"status"`
+}
+
+// TestResult is a single test attempt
+type TestResult struct {
+	Target         string  `json:"target"`
+	Run            int     `json:"run"`
+	Shard          int     `json:"shard"`
+	Attempt        int     `json:"attempt"`
+	Status         string  `json:"status"`
+	DurUs          float64 `json:"durUs"`
+	CachedLocally  bool    `json:"cachedLocally,omitempty"`
+	CachedRemotely bool    `json:"cachedRemotely,omitempty"`
+}
+
+// protoInt decodes proto3 JSON integers, which are quoted when 64-bit
+type protoInt int64
+
+func (n *protoInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = protoInt(v)
+	return nil
+}
+
+// bepEvent is the subset of a BuildEvent that gangaji reads
+type bepEvent struct {
+	ID struct {
+		TargetCompleted *struct {
+			Label string `json:"label"`
+		} `json:"targetCompleted"`
+		TestResult *struct {
+			Label   string `json:"label"`
+			Run     int    `json:"run"`
+			Shard   int    `json:"shard"`
+			Attempt int    `json:"attempt"`
+		} `json:"testResult"`
+		TestSummary *struct {
+			Label string `json:"label"`
+		} `json:"testSummary"`
+		StructuredCommandLine *struct {
+			CommandLineLabel string `json:"commandLineLabel"`
+		} `json:"structuredCommandLine"`
+	} `json:"id"`
+
+	Aborted *struct {
+		Reason string `json:"reason"`
+	} `json:"aborted"`
+	Started *struct {
+		Command string `json:"command"`
+	} `json:"started"`
+	Completed *struct {
+		Success bool `json:"success"`
+	} `json:"completed"`
+	TestResult *struct {
+		Status                    string   `json:"status"`
+		TestAttemptDurationMillis protoInt `json:"testAttemptDurationMillis"`
+		TestAttemptDuration       string   `json:"testAttemptDuration"`
+		CachedLocally             bool     `json:"cachedLocally"`
+		ExecutionInfo             struct {
+			CachedRemotely bool `json:"cachedRemotely"`
+		} `json:"executionInfo"`
+	} `json:"testResult"`
+	TestSummary *struct {
+		OverallStatus string `json:"overallStatus"`
+	} `json:"testSummary"`
+	StructuredCommandLine *struct {
+		Sections []struct {
+			ChunkList *struct {
+				Chunk []string `json:"chunk"`
+			} `json:"chunkList"`
+			OptionList *struct {
+				Option []struct {
+					CombinedForm string `json:"combinedForm"`
+					OptionName   string `json:"optionName"`
+					OptionValue  string `json:"optionValue"`
+				} `json:"option"`
+			} `json:"optionList"`
+		} `json:"sections"`
+	} `json:"structuredCommandLine"`
+	BuildMetrics *struct {
+		ActionSummary struct {
+			ActionCacheStatistics struct {
+				Hits   protoInt `json:"hits"`
+				Misses protoInt `json:"misses"`
+			} `json:"actionCacheStatistics"`
+		} `json:"actionSummary"`
+	} `json:"buildMetrics"`
+}
+
+func loadBuildEvents(path string) (*BuildEvents, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	// Check if gzipped
+	if strings.HasSuffix(path, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	result := &BuildEvents{}
+
+	// The file is a stream of JSON objects, one per line
+	dec := json.NewDecoder(bufio.NewReaderSize(reader, 1<<20))
+	for {
+		var event bepEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		result.add(&event)
+	}
+
+	return result, nil
+}
+
+// add records the parts of a single build event that gangaji cares about
+func (b *BuildEvents) add(event *bepEvent) {
+	id := event.ID
+
+	switch {
+	case event.Started != nil:
+		b.Command = event.Started.Command
+
+	case id.TargetCompleted != nil:
+		status := "FAILED"
+		if event.Aborted != nil {
+			status = "ABORTED"
+		} else if event.Completed != nil && event.Completed.Success {
+			status = "SUCCESS"
+		}
+		b.Targets = append(b.Targets, TargetOutcome{Target: id.TargetCompleted.Label, Status: status})
+
+	case id.TestResult != nil && event.TestResult != nil:
+		r := event.TestResult
+		durUs := float64(r.TestAttemptDurationMillis) * 1000
+		if d, err := time.ParseDuration(r.TestAttemptDuration); err == nil {
+			durUs = float64(d.Microseconds())
+		}
+		b.Tests = append(b.Tests, TestResult{
+			Target:         id.TestResult.Label,
+			Run:            id.TestResult.Run,
+			Shard:          id.TestResult.Shard,
+			Attempt:        id.TestResult.Attempt,
+			Status:         r.Status,
+			DurUs:          durUs,
+			CachedLocally:  r.CachedLocally,
+			CachedRemotely: r.ExecutionInfo.CachedRemotely,
+		})
+
+	case id.TestSummary != nil && event.TestSummary != nil:
+		b.TestSummaries = append(b.TestSummaries, TargetOutcome{
+			Target: id.TestSummary.Label,
+			Status: event.TestSummary.OverallStatus,
+		})
+
+	case id.StructuredCommandLine != nil && event.StructuredCommandLine != nil:
+		b.addCommandLine(id.StructuredCommandLine.CommandLineLabel, event)
+
+	case event.BuildMetrics != nil:
+		stats := event.BuildMetrics.ActionSummary.ActionCacheStatistics
+		b.ActionCacheHits = int64(stats.Hits)
+		b.ActionCacheMisses = int64(stats.Misses)
+	}
+}
+
+// addCommandLine keeps the original command line as typed and the options of
+// the canonical one, which has rc files and config expansion applied
+func (b *BuildEvents) addCommandLine(label string, event *bepEvent) {
+	for _, section := range event.StructuredCommandLine.Sections {
+		if label == "original" {
+			if section.ChunkList != nil {
+				b.CommandLine = append(b.CommandLine, section.ChunkList.Chunk...)
+			}
+			if section.OptionList != nil {
+				for _, opt := range section.OptionList.Option {
+					b.CommandLine = append(b.CommandLine, opt.CombinedForm)
+				}
+			}
+		}
+
+		if label == "canonical" && section.OptionList != nil {
+			for _, opt := range section.OptionList.Option {
+				b.Options = append(b.Options, BuildOption{Name: opt.OptionName, Value: opt.OptionValue})
+			}
+		}
+	}
+}
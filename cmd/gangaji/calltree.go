@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// callNode is one frame of a pprof call tree. A node's total covers its whole
+// subtree, so recursive functions are never counted twice.
+type callNode struct {
+	name     string
+	file     string
+	self     int64
+	total    int64
+	children []*callNode
+	index    map[callFrame]*callNode
+}
+
+// callFrame identifies a child frame under its parent
+type callFrame struct {
+	name string
+	file string
+}
+
+func newCallNode(frame callFrame) *callNode {
+	return &callNode{
+		name:  frame.name,
+		file:  frame.file,
+		index: make(map[callFrame]*callNode),
+	}
+}
+
+// child returns the child for frame, creating it in first-seen order
+func (n *callNode) child(frame callFrame) *callNode {
+	if c, ok := n.index[frame]; ok {
+		return c
+	}
+	c := newCallNode(frame)
+	n.index[frame] = c
+	n.children = append(n.children, c)
+	return c
+}
+
+// buildCallTree builds a call tree from pprof samples using the value at
+// sampleIndex. pprof stores stacks leaf first (and inlined frames innermost
+// first), so each stack is walked backwards from the root.
+func buildCallTree(prof *profile.Profile, sampleIndex int) *callNode {
+	root := newCallNode(callFrame{})
+
+	for _, sample := range prof.Sample {
+		if len(sample.Location) == 0 || sampleIndex >= len(sample.Value) {
+			continue
+		}
+		value := sample.Value[sampleIndex]
+		if value <= 0 {
+			continue
+		}
+
+		node := root
+		node.total += value
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			loc := sample.Location[i]
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				line := loc.Line[j]
+				if line.Function == nil {
+					continue
+				}
+				node = node.child(callFrame{name: line.Function.Name, file: line.Function.Filename})
+				node.total += value
+			}
+		}
+		node.self += value
+	}
+
+	return root
+}
+
+// sortLeftHeavy orders every node's children by total, heaviest first, which
+// merges the tree into the classic aggregated flamegraph shape
+func (n *callNode) sortLeftHeavy() {
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return n.children[i].total > n.children[j].total
+	})
+	for _, c := range n.children {
+		c.sortLeftHeavy()
+	}
+}
+
+// layout lays the subtree out on a synthetic timeline: each child starts where
+// its previous sibling ended, inside its parent's span. toUs converts sample
+// values to microseconds.
+func (n *callNode) layout(start int64, toUs func(int64) float64, emit func(node *callNode, ts, dur float64)) {
+	emit(n, toUs(start), toUs(n.total))
+
+	offset := start
+	for _, c := range n.children {
+		c.layout(offset, toUs, emit)
+		offset += c.total
+	}
+}
+
+// FunctionCost is the flat cost of one Starlark function for one sample type
+type FunctionCost struct {
+	Function   string
+	File       string
+	SampleType string
+	Unit       string
+	Value      int64
+}
+
+// starlarkFunctionCosts sums every sample type per function. A function is
+// counted once per sample even when it appears several times on the stack.
+func starlarkFunctionCosts(prof *profile.Profile) []FunctionCost {
+	totals := make(map[callFrame][]int64)
+	var order []callFrame
+
+	for _, sample := range prof.Sample {
+		seen := make(map[callFrame]bool)
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				frame := callFrame{name: line.Function.Name, file: line.Function.Filename}
+				if seen[frame] {
+					continue
+				}
+				seen[frame] = true
+
+				values, ok := totals[frame]
+				if !ok {
+					values = make([]int64, len(prof.SampleType))
+					totals[frame] = values
+					order = append(order, frame)
+				}
+				for i, v := range sample.Value {
+					if i < len(values) {
+						values[i] += v
+					}
+				}
+			}
+		}
+	}
+
+	var costs []FunctionCost
+	for _, frame := range order {
+		for i, st := range prof.SampleType {
+			costs = append(costs, FunctionCost{
+				Function:   frame.name,
+				File:       frame.file,
+				SampleType: st.Type,
+				Unit:       st.Unit,
+				Value:      totals[frame][i],
+			})
+		}
+	}
+	return costs
+}
+
+// timeUnitToUs returns the factor converting a pprof time unit to
+// microseconds, or false if the unit is not a duration
+func timeUnitToUs(unit string) (float64, bool) {
+	switch unit {
+	case "nanoseconds", "ns":
+		return 0.001, true
+	case "microseconds", "us":
+		return 1, true
+	case "milliseconds", "ms":
+		return 1000, true
+	case "seconds", "s":
+		return 1000000, true
+	default:
+		return 0, false
+	}
+}
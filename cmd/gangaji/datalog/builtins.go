@@ -44,6 +44,18 @@ func (e *Engine) RegisterFormattingBuiltins() {
 		return formatWithCommas(int64(val)), nil
 	})
 
+	// format_bytes formats a byte count to human-readable string
+	e.RegisterBuiltin("format_bytes", func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("format_bytes expects 1 argument")
+		}
+		val, err := toFloat64(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return FormatBytes(val), nil
+	})
+
 	// round_to rounds to n decimal places
 	e.RegisterBuiltin("round_to", func(args []interface{}) (interface{}, error) {
 		if len(args) != 2 {
@@ -178,6 +190,20 @@ func FormatDuration(us float64) string {
 	return fmt.Sprintf("%.0fh %dm", h, remainingM)
 }
 
+// FormatBytes formats a byte count to human-readable string
+func FormatBytes(bytes float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", bytes, units[i])
+}
+
 // formatWithCommas formats a number with thousands separators
 func formatWithCommas(n int64) string {
 	str := fmt.Sprintf("%d", n)
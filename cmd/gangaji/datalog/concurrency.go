@@ -0,0 +1,124 @@
+package datalog
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultConcurrencyBuckets is the number of concurrency buckets across the
+// build when no resolution is configured
+const defaultConcurrencyBuckets = 100
+
+// ConcurrencyStep is the number of running events from Ts until the next step
+type ConcurrencyStep struct {
+	Ts    float64
+	Count int
+}
+
+// ConcurrencyBucket summarizes the concurrency step function over one bucket
+type ConcurrencyBucket struct {
+	StartUs float64
+	Avg     float64
+	Max     int
+}
+
+// ComputeConcurrency sweeps the start and end points of the events for which
+// include returns true (all events when include is nil) and returns the
+// concurrency step function. At equal timestamps starts are applied before
+// ends, so events that touch count as overlapping for that instant.
+func ComputeConcurrency(events []TraceEvent, include func(i int) bool) []ConcurrencyStep {
+	type timePoint struct {
+		time  float64
+		delta int
+	}
+
+	points := make([]timePoint, 0, len(events)*2)
+	for i, e := range events {
+		if include != nil && !include(i) {
+			continue
+		}
+		points = append(points, timePoint{e.Ts, 1})
+		points = append(points, timePoint{e.Ts + e.Dur, -1})
+	}
+
+	sort.Slice(points, func(a, b int) bool {
+		if points[a].time != points[b].time {
+			return points[a].time < points[b].time
+		}
+		return points[a].delta > points[b].delta
+	})
+
+	steps := make([]ConcurrencyStep, 0, len(points))
+	current := 0
+	for _, p := range points {
+		current += p.delta
+		steps = append(steps, ConcurrencyStep{Ts: p.time, Count: current})
+	}
+	return steps
+}
+
+// maxConcurrency returns the highest count in the step function
+func maxConcurrency(steps []ConcurrencyStep) int {
+	max := 0
+	for _, s := range steps {
+		if s.Count > max {
+			max = s.Count
+		}
+	}
+	return max
+}
+
+// ConcurrencyBuckets averages the step function over buckets of bucketUs
+// starting at start. Max includes instantaneous peaks.
+func ConcurrencyBuckets(steps []ConcurrencyStep, start, end, bucketUs float64) []ConcurrencyBucket {
+	if bucketUs <= 0 || end <= start {
+		return nil
+	}
+
+	n := int(math.Ceil((end - start) / bucketUs))
+	buckets := make([]ConcurrencyBucket, n)
+	area := make([]float64, n)
+	for i := range buckets {
+		buckets[i].StartUs = start + float64(i)*bucketUs
+	}
+
+	bucketOf := func(ts float64) int {
+		i := int((ts - start) / bucketUs)
+		if i >= n {
+			i = n - 1
+		}
+		if i < 0 {
+			i = 0
+		}
+		return i
+	}
+
+	for i, s := range steps {
+		b := bucketOf(s.Ts)
+		if s.Count > buckets[b].Max {
+			buckets[b].Max = s.Count
+		}
+		if i+1 == len(steps) || s.Count == 0 {
+			continue
+		}
+
+		// The level holds until the next step; spread it over the buckets it spans
+		from, to := s.Ts, steps[i+1].Ts
+		for j := b; j < n && from < to; j++ {
+			bucketEnd := buckets[j].StartUs + bucketUs
+			segment := math.Min(to, bucketEnd) - from
+			area[j] += segment * float64(s.Count)
+			if s.Count > buckets[j].Max {
+				buckets[j].Max = s.Count
+			}
+			from = bucketEnd
+		}
+	}
+
+	// The last bucket may be cut short by the end of the build
+	for i := range buckets {
+		width := math.Min(bucketUs, end-buckets[i].StartUs)
+		buckets[i].Avg = area[i] / width
+	}
+	return buckets
+}
@@ -0,0 +1,103 @@
+package datalog
+
+import "sort"
+
+// CounterEvent mirrors main.CounterEvent (a ph: "C" sample)
+type CounterEvent struct {
+	Name string
+	Ts   float64
+	Pid  int
+	Args map[string]interface{}
+}
+
+// CounterStats summarizes the samples of one counter series
+type CounterStats struct {
+	Min float64
+	Max float64
+	Avg float64
+	P95 float64
+}
+
+// counterSeries identifies one value of a counter: "Memory usage (Bazel)" has
+// a single "memory" series, "CPU usage (total)" a "system cpu" series
+type counterSeries struct {
+	name, series string
+}
+
+// generateCounterFacts turns counter samples into counter_sample facts and
+// summarizes every series with counter_stats, once over the whole profile and
+// once over the execution window [execStart, execEnd]
+func generateCounterFacts(counters []CounterEvent, execStart, execEnd float64) []Fact {
+	var facts []Fact
+
+	all := make(map[counterSeries][]float64)
+	execution := make(map[counterSeries][]float64)
+	var order []counterSeries
+
+	for _, c := range counters {
+		keys := make([]string, 0, len(c.Args))
+		for k := range c.Args {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			value, err := toFloat64(c.Args[k])
+			if err != nil {
+				continue
+			}
+
+			// counter_sample(name, series, ts_us, value)
+			facts = append(facts, Fact{
+				Predicate: "counter_sample",
+				Args:      []interface{}{c.Name, k, c.Ts, value},
+			})
+
+			key := counterSeries{c.Name, k}
+			if _, ok := all[key]; !ok {
+				order = append(order, key)
+			}
+			all[key] = append(all[key], value)
+			if c.Ts >= execStart && c.Ts <= execEnd {
+				execution[key] = append(execution[key], value)
+			}
+		}
+	}
+
+	for _, key := range order {
+		stats := computeCounterStats(all[key])
+		// counter_stats(name, series, min, max, avg, p95)
+		facts = append(facts, Fact{
+			Predicate: "counter_stats",
+			Args:      []interface{}{key.name, key.series, stats.Min, stats.Max, stats.Avg, stats.P95},
+		})
+
+		if values := execution[key]; len(values) > 0 {
+			stats := computeCounterStats(values)
+			// counter_execution_stats(name, series, min, max, avg, p95)
+			facts = append(facts, Fact{
+				Predicate: "counter_execution_stats",
+				Args:      []interface{}{key.name, key.series, stats.Min, stats.Max, stats.Avg, stats.P95},
+			})
+		}
+	}
+
+	return facts
+}
+
+// computeCounterStats summarizes values, sorting them in place
+func computeCounterStats(values []float64) CounterStats {
+	sort.Float64s(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return CounterStats{
+		Min: values[0],
+		Max: values[len(values)-1],
+		Avg: sum / float64(len(values)),
+		P95: percentileSorted(values, 95),
+	}
+}
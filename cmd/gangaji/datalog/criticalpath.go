@@ -0,0 +1,123 @@
+package datalog
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// criticalPathCategory is the category of the events Bazel writes for each
+// action on the critical path it computed
+const criticalPathCategory = "critical path component"
+
+// CriticalPathStep is one action on the critical path reported by Bazel
+type CriticalPathStep struct {
+	Index     int     `json:"index"` // Position on the path of the step's pid
+	Pid       int     `json:"pid"`
+	Event     int     `json:"event"`     // Matched action span, or Component if none matched
+	Component int     `json:"component"` // The "critical path component" event
+	Name      string  `json:"name"`
+	Target    string  `json:"target,omitempty"`
+	Mnemonic  string  `json:"mnemonic,omitempty"`
+	Ts        float64 `json:"ts"`
+	DurUs     float64 `json:"durUs"`
+}
+
+// ComputeCriticalPathSteps rebuilds the critical path chain from Bazel's
+// "critical path component" events, in path order. Every pid (profile source)
+// has its own path, numbered from 0; paths are returned by pid. Each component
+// is matched to the action span with the same description on the same pid
+// that overlaps it the most.
+func ComputeCriticalPathSteps(events []TraceEvent) []CriticalPathStep {
+	type actionKey struct {
+		pid  int
+		name string
+	}
+
+	var components []int
+	actions := make(map[actionKey][]int)
+	for i, e := range events {
+		if e.Cat == criticalPathCategory {
+			components = append(components, i)
+			continue
+		}
+		if target, ok := e.Args["target"].(string); ok && target != "" {
+			key := actionKey{e.Pid, e.Name}
+			actions[key] = append(actions[key], i)
+		}
+	}
+	if len(components) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(components, func(a, b int) bool {
+		ca, cb := events[components[a]], events[components[b]]
+		if ca.Pid != cb.Pid {
+			return ca.Pid < cb.Pid
+		}
+		return ca.Ts < cb.Ts
+	})
+
+	steps := make([]CriticalPathStep, 0, len(components))
+	index := 0
+	for i, c := range components {
+		comp := events[c]
+		if i > 0 && comp.Pid != events[components[i-1]].Pid {
+			index = 0
+		}
+		step := CriticalPathStep{
+			Index:     index,
+			Pid:       comp.Pid,
+			Event:     c,
+			Component: c,
+			Name:      criticalPathActionName(comp.Name),
+			Ts:        comp.Ts,
+			DurUs:     comp.Dur,
+		}
+
+		if match := bestOverlap(events, actions[actionKey{comp.Pid, step.Name}], comp); match >= 0 {
+			action := events[match]
+			step.Event = match
+			step.Target, _ = action.Args["target"].(string)
+			step.Mnemonic, _ = action.Args["mnemonic"].(string)
+		}
+
+		steps = append(steps, step)
+		index++
+	}
+
+	return steps
+}
+
+// criticalPathActionName strips the "action '...'" wrapper older Bazel
+// versions put around the action description
+func criticalPathActionName(name string) string {
+	if strings.HasPrefix(name, "action '") && strings.HasSuffix(name, "'") {
+		return name[len("action '") : len(name)-1]
+	}
+	return name
+}
+
+// bestOverlap returns the candidate overlapping comp the most, falling back to
+// the one ending closest to it, or -1 if there are no candidates
+func bestOverlap(events []TraceEvent, candidates []int, comp TraceEvent) int {
+	best := -1
+	bestOverlap := 0.0
+	bestDistance := math.Inf(1)
+	compEnd := comp.Ts + comp.Dur
+
+	for _, i := range candidates {
+		e := events[i]
+		end := e.Ts + e.Dur
+		overlap := math.Max(math.Min(end, compEnd)-math.Max(e.Ts, comp.Ts), 0)
+		distance := math.Abs(end - compEnd)
+
+		if overlap > bestOverlap || (overlap == bestOverlap && distance < bestDistance) {
+			best = i
+			bestOverlap = overlap
+			bestDistance = distance
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,116 @@
+package datalog
+
+// dependencyGraph links the head predicate of every rule to the predicates
+// its body reads, including inside negations and aggregates
+type dependencyGraph struct {
+	predicates []string                // Head predicates in rule order
+	edges      map[string][]dependency // Head predicate -> body predicates
+}
+
+// dependency is a predicate read by a rule body. A predicate read through
+// "not" or inside an aggregate must be complete before the rule runs.
+type dependency struct {
+	predicate string
+	through   string // "", "not" or "aggregate"
+}
+
+func newDependencyGraph(rules []Rule) *dependencyGraph {
+	g := &dependencyGraph{edges: make(map[string][]dependency)}
+	for _, rule := range rules {
+		head := rule.Head.Predicate
+		if _, ok := g.edges[head]; !ok {
+			g.predicates = append(g.predicates, head)
+			g.edges[head] = nil
+		}
+		g.edges[head] = append(g.edges[head], bodyDependencies(rule.Body, "")...)
+	}
+	return g
+}
+
+// bodyDependencies returns the predicates a rule body reads; through is set
+// for bodies nested in an aggregate
+func bodyDependencies(clauses []Clause, through string) []dependency {
+	var deps []dependency
+	for _, clause := range clauses {
+		switch c := clause.(type) {
+		case AtomClause:
+			deps = append(deps, dependency{c.Atom.Predicate, through})
+		case Negation:
+			if through == "" {
+				deps = append(deps, dependency{c.Atom.Predicate, "not"})
+			} else {
+				deps = append(deps, dependency{c.Atom.Predicate, through})
+			}
+		case Aggregation:
+			deps = append(deps, bodyDependencies(c.Body, "aggregate")...)
+		}
+	}
+	return deps
+}
+
+// components returns the strongly connected components of the graph (Tarjan's
+// algorithm), each after every component it depends on. Predicates that are
+// only read (base facts) are left out.
+func (g *dependencyGraph) components() [][]string {
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var result [][]string
+
+	var visit func(pred string)
+	visit = func(pred string) {
+		index[pred] = len(index)
+		lowlink[pred] = index[pred]
+		stack = append(stack, pred)
+		onStack[pred] = true
+
+		for _, d := range g.edges[pred] {
+			dep := d.predicate
+			if _, derived := g.edges[dep]; !derived {
+				continue
+			}
+			if _, seen := index[dep]; !seen {
+				visit(dep)
+				lowlink[pred] = min(lowlink[pred], lowlink[dep])
+			} else if onStack[dep] {
+				lowlink[pred] = min(lowlink[pred], index[dep])
+			}
+		}
+
+		if lowlink[pred] == index[pred] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == pred {
+					break
+				}
+			}
+			result = append(result, component)
+		}
+	}
+
+	for _, pred := range g.predicates {
+		if _, seen := index[pred]; !seen {
+			visit(pred)
+		}
+	}
+	return result
+}
+
+// recursive reports whether the predicates of a component depend on each other
+// (more than one predicate, or one that reads itself)
+func (g *dependencyGraph) recursive(component []string) bool {
+	if len(component) > 1 {
+		return true
+	}
+	for _, dep := range g.edges[component[0]] {
+		if dep.predicate == component[0] {
+			return true
+		}
+	}
+	return false
+}
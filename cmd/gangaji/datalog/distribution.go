@@ -0,0 +1,153 @@
+package datalog
+
+import (
+	"math"
+	"sort"
+)
+
+// distributionPercentiles are the percentiles emitted as *_percentile facts
+var distributionPercentiles = []float64{50, 90, 99}
+
+// DurationStats summarizes the durations of a group of events
+type DurationStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+}
+
+// Distributions holds duration statistics of actions grouped three ways
+type Distributions struct {
+	Mnemonic map[string]DurationStats `json:"mnemonic"` // Actionable events with a target
+	Package  map[string]DurationStats `json:"package"`  // Events with a target, by the target's package
+	Category map[string]DurationStats `json:"category"` // All events
+}
+
+// ComputeDistributions groups event durations by mnemonic, target package and
+// category and summarizes each group
+func ComputeDistributions(events []TraceEvent) *Distributions {
+	byMnemonic := make(map[string][]float64)
+	byPackage := make(map[string][]float64)
+	byCategory := make(map[string][]float64)
+
+	for _, e := range events {
+		byCategory[e.Cat] = append(byCategory[e.Cat], e.Dur)
+
+		target, ok := e.Args["target"].(string)
+		if !ok || target == "" {
+			continue
+		}
+		byPackage[targetPackage(target)] = append(byPackage[targetPackage(target)], e.Dur)
+		if mnemonic, ok := e.Args["mnemonic"].(string); ok {
+			byMnemonic[mnemonic] = append(byMnemonic[mnemonic], e.Dur)
+		}
+	}
+
+	return &Distributions{
+		Mnemonic: summarizeGroups(byMnemonic),
+		Package:  summarizeGroups(byPackage),
+		Category: summarizeGroups(byCategory),
+	}
+}
+
+// targetPackage returns the package of a label ("//foo/bar:baz" -> "//foo/bar"),
+// or the label itself when it has no package
+func targetPackage(target string) string {
+	if label, err := ParseLabel(target); err == nil && !label.Relative {
+		return label.PackageLabel()
+	}
+	return target
+}
+
+func summarizeGroups(groups map[string][]float64) map[string]DurationStats {
+	result := make(map[string]DurationStats, len(groups))
+	for key, durations := range groups {
+		result[key] = ComputeDurationStats(durations)
+	}
+	return result
+}
+
+// ComputeDurationStats returns count, mean, population stddev, nearest-rank
+// percentiles and max of durations. durations is sorted in place.
+func ComputeDurationStats(durations []float64) DurationStats {
+	stats := DurationStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sort.Float64s(durations)
+
+	var sum float64
+	for _, d := range durations {
+		sum += d
+	}
+	stats.Mean = sum / float64(len(durations))
+
+	var variance float64
+	for _, d := range durations {
+		variance += (d - stats.Mean) * (d - stats.Mean)
+	}
+	stats.Stddev = math.Sqrt(variance / float64(len(durations)))
+
+	stats.P50 = percentileSorted(durations, 50)
+	stats.P90 = percentileSorted(durations, 90)
+	stats.P99 = percentileSorted(durations, 99)
+	stats.Max = durations[len(durations)-1]
+	return stats
+}
+
+// percentileSorted returns the nearest-rank percentile p (0-100) of sorted values
+func percentileSorted(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// percentile returns the value of stats for one of distributionPercentiles
+func (s DurationStats) percentile(p float64) float64 {
+	switch p {
+	case 50:
+		return s.P50
+	case 90:
+		return s.P90
+	default:
+		return s.P99
+	}
+}
+
+// generateDistributionFacts emits <prefix>_percentile(Key, P, DurUs) and
+// <prefix>_stats(Key, Count, Mean, Stddev, Max) for every group
+func generateDistributionFacts(prefix string, groups map[string]DurationStats) []Fact {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var facts []Fact
+	for _, key := range keys {
+		stats := groups[key]
+		for _, p := range distributionPercentiles {
+			facts = append(facts, Fact{
+				Predicate: prefix + "_percentile",
+				Args:      []interface{}{key, int(p), stats.percentile(p)},
+			})
+		}
+		facts = append(facts, Fact{
+			Predicate: prefix + "_stats",
+			Args:      []interface{}{key, stats.Count, stats.Mean, stats.Stddev, stats.Max},
+		})
+	}
+	return facts
+}
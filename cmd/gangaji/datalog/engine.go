@@ -8,7 +8,7 @@ import (
 
 // Engine evaluates Datalog programs
 type Engine struct {
-	facts    map[string][]Fact // predicate -> facts
+	facts    map[string]*relation // predicate -> facts
 	rules    []Rule
 	builtins map[string]BuiltinFunc
 }
@@ -19,7 +19,7 @@ type BuiltinFunc func(args []interface{}) (interface{}, error)
 // NewEngine creates a new Datalog engine
 func NewEngine() *Engine {
 	e := &Engine{
-		facts:    make(map[string][]Fact),
+		facts:    make(map[string]*relation),
 		builtins: make(map[string]BuiltinFunc),
 	}
 	e.registerDefaultBuiltins()
@@ -31,9 +31,28 @@ func (e *Engine) RegisterBuiltin(name string, fn BuiltinFunc) {
 	e.builtins[name] = fn
 }
 
-// AddFact adds a fact to the database
+// CallBuiltin calls a registered built-in function by name
+func (e *Engine) CallBuiltin(name string, args []interface{}) (interface{}, error) {
+	fn, ok := e.builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s", name)
+	}
+	return fn(args)
+}
+
+// AddFact adds a fact to the database; facts already present are ignored
 func (e *Engine) AddFact(f Fact) {
-	e.facts[f.Predicate] = append(e.facts[f.Predicate], f)
+	e.addFact(f)
+}
+
+// addFact adds a fact and reports whether it was new
+func (e *Engine) addFact(f Fact) bool {
+	rel, ok := e.facts[f.Predicate]
+	if !ok {
+		rel = newRelation()
+		e.facts[f.Predicate] = rel
+	}
+	return rel.add(f)
 }
 
 // AddFacts adds multiple facts to the database
@@ -60,38 +79,86 @@ func (e *Engine) LoadProgram(program *Program) {
 
 // GetFacts returns all facts for a predicate
 func (e *Engine) GetFacts(predicate string) []Fact {
-	return e.facts[predicate]
+	if rel, ok := e.facts[predicate]; ok {
+		return rel.facts
+	}
+	return nil
 }
 
-// Evaluate runs the Datalog program until fixpoint
+// Evaluate runs the Datalog program until fixpoint. Rules are evaluated one
+// stratum at a time (see Stratify), so predicates read through negation or
+// aggregation are complete before they are read. Non-recursive strata run
+// once; recursive ones run semi-naively, re-evaluating only the rule
+// instantiations that read facts derived in the previous round.
 func (e *Engine) Evaluate() error {
-	// Semi-naive bottom-up evaluation
-	for {
-		newFacts := 0
+	strata, err := Stratify(e.rules)
+	if err != nil {
+		return err
+	}
+	for _, stratum := range strata {
+		if err := e.evaluateStratum(stratum.Rules, stratum.Recursive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		for _, rule := range e.rules {
-			derived, err := e.evaluateRule(rule)
-			if err != nil {
-				return err
+// evaluateStratum evaluates the rules of one stratum to fixpoint
+func (e *Engine) evaluateStratum(rules []Rule, recursive bool) error {
+	// First round: every rule against the full database
+	delta := make(map[string]*relation)
+	for _, rule := range rules {
+		derived, err := e.evaluateRule(rule)
+		if err != nil {
+			return err
+		}
+		for _, fact := range derived {
+			if e.addFact(fact) && recursive {
+				addToDelta(delta, fact)
 			}
+		}
+	}
 
-			for _, fact := range derived {
-				if !e.factExists(fact) {
-					e.AddFact(fact)
-					newFacts++
+	// Later rounds: each body atom in turn reads only the previous round's facts
+	for len(delta) > 0 {
+		next := make(map[string]*relation)
+		for _, rule := range rules {
+			for i, clause := range rule.Body {
+				atom, ok := clause.(AtomClause)
+				if !ok {
+					continue
+				}
+				d, ok := delta[atom.Atom.Predicate]
+				if !ok {
+					continue
 				}
-			}
-		}
 
-		// Fixpoint reached
-		if newFacts == 0 {
-			break
+				bindings, err := e.evaluateBodyDelta(rule.Body, []Bindings{make(Bindings)}, i, d)
+				if err != nil {
+					return err
+				}
+				for _, fact := range e.instantiateHead(rule.Head, bindings) {
+					if e.addFact(fact) {
+						addToDelta(next, fact)
+					}
+				}
+			}
 		}
+		delta = next
 	}
-
 	return nil
 }
 
+// addToDelta records a newly derived fact in its predicate's delta relation
+func addToDelta(delta map[string]*relation, fact Fact) {
+	rel, ok := delta[fact.Predicate]
+	if !ok {
+		rel = newRelation()
+		delta[fact.Predicate] = rel
+	}
+	rel.add(fact)
+}
+
 // evaluateRule evaluates a single rule and returns derived facts
 func (e *Engine) evaluateRule(rule Rule) ([]Fact, error) {
 	// Find all bindings that satisfy the body
@@ -100,28 +167,44 @@ func (e *Engine) evaluateRule(rule Rule) ([]Fact, error) {
 		return nil, err
 	}
 
-	// Generate facts from bindings
+	return e.instantiateHead(rule.Head, bindings), nil
+}
+
+// instantiateHead builds the head fact for every binding that binds all of its variables
+func (e *Engine) instantiateHead(head Atom, bindings []Bindings) []Fact {
 	var facts []Fact
 	for _, b := range bindings {
-		fact, err := e.instantiateAtom(rule.Head, b)
+		fact, err := e.instantiateAtom(head, b)
 		if err != nil {
 			continue // Skip if can't instantiate
 		}
 		facts = append(facts, fact)
 	}
 
-	return facts, nil
+	return facts
 }
 
 // evaluateBody evaluates the body clauses and returns satisfying bindings
 func (e *Engine) evaluateBody(clauses []Clause, bindings []Bindings) ([]Bindings, error) {
+	return e.evaluateBodyDelta(clauses, bindings, -1, nil)
+}
+
+// evaluateBodyDelta evaluates the body clauses, matching the atom at deltaAt
+// against delta instead of the full database
+func (e *Engine) evaluateBodyDelta(clauses []Clause, bindings []Bindings, deltaAt int, delta *relation) ([]Bindings, error) {
 	result := bindings
 
-	for _, clause := range clauses {
+	for i, clause := range clauses {
 		var newBindings []Bindings
 
 		for _, b := range result {
-			extended, err := e.evaluateClause(clause, b)
+			var extended []Bindings
+			var err error
+			if i == deltaAt {
+				extended, err = e.matchAtom(delta, clause.(AtomClause).Atom, b)
+			} else {
+				extended, err = e.evaluateClause(clause, b)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -155,12 +238,49 @@ func (e *Engine) evaluateClause(clause Clause, bindings Bindings) ([]Bindings, e
 	}
 }
 
-// evaluateAtom evaluates an atom against the fact database
+// evaluateAtom evaluates an atom against the fact database. Arguments that are
+// constants or already bound narrow the candidates through an index.
 func (e *Engine) evaluateAtom(atom Atom, bindings Bindings) ([]Bindings, error) {
-	facts := e.facts[atom.Predicate]
-	var result []Bindings
+	rel, ok := e.facts[atom.Predicate]
+	if !ok {
+		return nil, nil
+	}
+	return e.matchAtom(rel, atom, bindings)
+}
+
+// matchAtom extends bindings with every fact of rel that matches atom
+func (e *Engine) matchAtom(rel *relation, atom Atom, bindings Bindings) ([]Bindings, error) {
+	var mask uint64
+	var bound []interface{}
+	for i, arg := range atom.Args {
+		if i >= 64 {
+			break
+		}
+		switch a := arg.(type) {
+		case Constant:
+			mask |= 1 << uint(i)
+			bound = append(bound, a.Value)
+		case Variable:
+			if val, ok := bindings[a]; ok {
+				mask |= 1 << uint(i)
+				bound = append(bound, val)
+			}
+		}
+	}
 
-	for _, fact := range facts {
+	var candidates []int
+	if mask != 0 {
+		candidates = rel.lookup(mask, bound)
+	} else {
+		candidates = make([]int, len(rel.facts))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+
+	var result []Bindings
+	for _, id := range candidates {
+		fact := rel.facts[id]
 		if len(fact.Args) != len(atom.Args) {
 			continue
 		}
@@ -236,7 +356,9 @@ func (e *Engine) evaluateAssignment(assign Assignment, bindings Bindings) ([]Bin
 	return []Bindings{newBindings}, nil
 }
 
-// evaluateAggregation evaluates an aggregation clause
+// evaluateAggregation evaluates an aggregation. Without group variables it
+// yields one binding; with them, one binding per distinct group in the body's
+// results, with the group variables bound alongside the result.
 func (e *Engine) evaluateAggregation(agg Aggregation, bindings Bindings) ([]Bindings, error) {
 	// Find all bindings that satisfy the body
 	bodyBindings, err := e.evaluateBody(agg.Body, []Bindings{bindings.Clone()})
@@ -244,37 +366,158 @@ func (e *Engine) evaluateAggregation(agg Aggregation, bindings Bindings) ([]Bind
 		return nil, err
 	}
 
-	// Collect values to aggregate
-	var values []float64
+	// Split the body's results into groups in order of first appearance
+	type group struct {
+		keys []interface{}
+		rows []Bindings
+	}
+	var order []string
+	groups := make(map[string]*group)
 	for _, b := range bodyBindings {
-		if agg.Op == AggCount {
-			values = append(values, 1)
-		} else {
+		keys := make([]interface{}, len(agg.GroupBy))
+		for i, v := range agg.GroupBy {
+			val, err := e.resolveTerm(v, b)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = val
+		}
+		key := tupleKey(keys)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{keys: keys}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.rows = append(g.rows, b)
+	}
+
+	// Without grouping an empty body still aggregates (count and sum are 0)
+	if len(agg.GroupBy) == 0 && len(order) == 0 {
+		order = append(order, "")
+		groups[""] = &group{}
+	}
+
+	var results []Bindings
+	for _, key := range order {
+		g := groups[key]
+		newBindings := bindings.Clone()
+		if !e.aggregateRows(agg, g.rows, newBindings) {
+			continue
+		}
+		for i, v := range agg.GroupBy {
+			newBindings[v] = g.keys[i]
+		}
+		results = append(results, newBindings)
+	}
+	return results, nil
+}
+
+// aggregateRows binds agg.Into (and the argmax/argmin witnesses) in result
+// from the body bindings of one group; false when the op has no value for the
+// rows, such as the max of nothing
+func (e *Engine) aggregateRows(agg Aggregation, rows []Bindings, result Bindings) bool {
+	switch agg.Op {
+	case AggCount:
+		result[agg.Into] = float64(len(rows))
+		return true
+
+	case AggCountDistinct, AggCollect:
+		// Any value type; rows without a value for the variable are skipped
+		var values []interface{}
+		seen := make(map[string]bool)
+		for _, b := range rows {
 			val, err := e.resolveTerm(agg.Variable, b)
 			if err != nil {
 				continue
 			}
-			numVal, err := toFloat64(val)
-			if err != nil {
+			if agg.Op == AggCountDistinct {
+				key := valueKey(val)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			values = append(values, val)
+		}
+		if agg.Op == AggCountDistinct {
+			result[agg.Into] = float64(len(values))
+		} else {
+			if values == nil {
+				values = []interface{}{}
+			}
+			result[agg.Into] = values
+		}
+		return true
+
+	case AggArgMax, AggArgMin:
+		// The first row with the largest (smallest) value wins ties
+		var best Bindings
+		var bestVal float64
+		for _, b := range rows {
+			val, ok := e.numericValue(agg.Variable, b)
+			if !ok {
 				continue
 			}
-			values = append(values, numVal)
+			if best == nil || (agg.Op == AggArgMax && val > bestVal) || (agg.Op == AggArgMin && val < bestVal) {
+				best, bestVal = b, val
+			}
+		}
+		if best == nil {
+			return false
+		}
+		for _, w := range agg.Witnesses {
+			val, err := e.resolveTerm(w, best)
+			if err != nil {
+				return false
+			}
+			result[w] = val
+		}
+		result[agg.Into] = bestVal
+		return true
+	}
+
+	var values []float64
+	for _, b := range rows {
+		if val, ok := e.numericValue(agg.Variable, b); ok {
+			values = append(values, val)
 		}
 	}
+	value, ok := aggregateValues(agg.Op, agg.Param, values)
+	if ok {
+		result[agg.Into] = value
+	}
+	return ok
+}
+
+// numericValue resolves v in bindings as a number
+func (e *Engine) numericValue(v Variable, bindings Bindings) (float64, bool) {
+	val, err := e.resolveTerm(v, bindings)
+	if err != nil {
+		return 0, false
+	}
+	num, err := toFloat64(val)
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
+// aggregateValues computes a numeric op over values, with param the
+// percentile for percentile; false when op has no value for an empty input
+// (every op but sum)
+func aggregateValues(op AggregateOp, param float64, values []float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, op == AggSum
+	}
 
-	// Compute aggregate
 	var result float64
-	switch agg.Op {
-	case AggCount:
-		result = float64(len(values))
+	switch op {
 	case AggSum:
 		for _, v := range values {
 			result += v
 		}
 	case AggMax:
-		if len(values) == 0 {
-			return nil, nil
-		}
 		result = values[0]
 		for _, v := range values[1:] {
 			if v > result {
@@ -282,9 +525,6 @@ func (e *Engine) evaluateAggregation(agg Aggregation, bindings Bindings) ([]Bind
 			}
 		}
 	case AggMin:
-		if len(values) == 0 {
-			return nil, nil
-		}
 		result = values[0]
 		for _, v := range values[1:] {
 			if v < result {
@@ -292,18 +532,30 @@ func (e *Engine) evaluateAggregation(agg Aggregation, bindings Bindings) ([]Bind
 			}
 		}
 	case AggAvg:
-		if len(values) == 0 {
-			return nil, nil
-		}
 		for _, v := range values {
 			result += v
 		}
 		result /= float64(len(values))
+	case AggMedian, AggPercentile:
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		if op == AggMedian {
+			result = medianSorted(sorted)
+		} else {
+			result = percentileSorted(sorted, param)
+		}
+	case AggStddev:
+		var mean float64
+		for _, v := range values {
+			mean += v
+		}
+		mean /= float64(len(values))
+		for _, v := range values {
+			result += (v - mean) * (v - mean)
+		}
+		result = math.Sqrt(result / float64(len(values)))
 	}
-
-	newBindings := bindings.Clone()
-	newBindings[agg.Into] = result
-	return []Bindings{newBindings}, nil
+	return result, true
 }
 
 // evaluateNegation evaluates a negation-as-failure clause
@@ -417,16 +669,6 @@ func (e *Engine) instantiateAtom(atom Atom, bindings Bindings) (Fact, error) {
 	return Fact{Predicate: atom.Predicate, Args: args}, nil
 }
 
-// factExists checks if a fact already exists in the database
-func (e *Engine) factExists(fact Fact) bool {
-	for _, f := range e.facts[fact.Predicate] {
-		if factsEqual(f, fact) {
-			return true
-		}
-	}
-	return false
-}
-
 // EvaluateSuggestionRule evaluates a suggestion rule and returns matching bindings
 func (e *Engine) EvaluateSuggestionRule(rule SuggestionRule) ([]Bindings, error) {
 	return e.evaluateBody(rule.Conditions, []Bindings{make(Bindings)})
@@ -449,8 +691,8 @@ func (e *Engine) QueryOne(atom Atom) (Bindings, bool) {
 // FactCount returns the total number of facts
 func (e *Engine) FactCount() int {
 	count := 0
-	for _, facts := range e.facts {
-		count += len(facts)
+	for _, rel := range e.facts {
+		count += len(rel.facts)
 	}
 	return count
 }
@@ -479,18 +721,6 @@ func valuesEqual(a, b interface{}) bool {
 	return fmt.Sprint(a) == fmt.Sprint(b)
 }
 
-func factsEqual(a, b Fact) bool {
-	if a.Predicate != b.Predicate || len(a.Args) != len(b.Args) {
-		return false
-	}
-	for i := range a.Args {
-		if !valuesEqual(a.Args[i], b.Args[i]) {
-			return false
-		}
-	}
-	return true
-}
-
 func compareValues(left, right interface{}, op ComparisonOp) (bool, error) {
 	// Try numeric comparison first
 	leftNum, leftOk := toFloat64NoErr(left)
@@ -0,0 +1,210 @@
+package datalog
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// evaluate parses and evaluates src, failing the test on any error
+func evaluate(t *testing.T, src string) *Engine {
+	t.Helper()
+	program, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	engine := NewEngine()
+	engine.LoadProgram(program)
+	if err := engine.Evaluate(); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	return engine
+}
+
+// assertFacts checks that predicate holds exactly the facts in want, in any order
+func assertFacts(t *testing.T, engine *Engine, predicate string, want ...string) {
+	t.Helper()
+	var got []string
+	for _, f := range engine.GetFacts(predicate) {
+		got = append(got, f.String())
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s facts:\ngot  %v\nwant %v", predicate, got, want)
+	}
+}
+
+func TestDuplicateFacts(t *testing.T) {
+	engine := evaluate(t, `
+edge(1, 2). edge(1, 2). edge(1, 2.0).
+src(?X) :- edge(?X, _).
+`)
+	assertFacts(t, engine, "edge", "edge(1, 2)")
+	assertFacts(t, engine, "src", "src(1)")
+}
+
+func TestPartiallyBoundAtoms(t *testing.T) {
+	engine := evaluate(t, `
+span(1, "a", 10). span(2, "a", 20). span(3, "b", 20). span(4, "b", 30).
+pick("a"). pick("b").
+by_name(?N, ?Id) :- pick(?N), span(?Id, ?N, _).
+by_name_dur(?N, ?Id) :- pick(?N), span(?Id, ?N, 20).
+same_dur(?A, ?B) :- span(?A, _, ?D), span(?B, _, ?D), ?A < ?B.
+`)
+	assertFacts(t, engine, "by_name",
+		`by_name("a", 1)`, `by_name("a", 2)`, `by_name("b", 3)`, `by_name("b", 4)`)
+	assertFacts(t, engine, "by_name_dur", `by_name_dur("a", 2)`, `by_name_dur("b", 3)`)
+	assertFacts(t, engine, "same_dur", "same_dur(2, 3)")
+}
+
+func TestTransitiveClosure(t *testing.T) {
+	engine := evaluate(t, `
+edge(1, 2). edge(2, 3). edge(3, 4). edge(4, 2).
+reach(?X, ?Y) :- edge(?X, ?Y).
+reach(?X, ?Z) :- reach(?X, ?Y), edge(?Y, ?Z).
+`)
+	assertFacts(t, engine, "reach",
+		"reach(1, 2)", "reach(1, 3)", "reach(1, 4)",
+		"reach(2, 2)", "reach(2, 3)", "reach(2, 4)",
+		"reach(3, 2)", "reach(3, 3)", "reach(3, 4)",
+		"reach(4, 2)", "reach(4, 3)", "reach(4, 4)")
+}
+
+func TestMutualRecursion(t *testing.T) {
+	engine := evaluate(t, `
+succ(0, 1). succ(1, 2). succ(2, 3). succ(3, 4).
+even(0).
+even(?Y) :- odd(?X), succ(?X, ?Y).
+odd(?Y) :- even(?X), succ(?X, ?Y).
+`)
+	assertFacts(t, engine, "even", "even(0)", "even(2)", "even(4)")
+	assertFacts(t, engine, "odd", "odd(1)", "odd(3)")
+}
+
+func TestNegationAfterStratum(t *testing.T) {
+	engine := evaluate(t, `
+node(1). node(2). node(3). edge(1, 2).
+reach(?X, ?Y) :- edge(?X, ?Y).
+reach(?X, ?Z) :- reach(?X, ?Y), edge(?Y, ?Z).
+isolated(?X) :- node(?X), not reach(?X, _), not reach(_, ?X).
+`)
+	assertFacts(t, engine, "isolated", "isolated(3)")
+}
+
+func TestStratifyRejectsCycle(t *testing.T) {
+	program, err := Parse(`
+a(?X) :- node(?X), not b(?X).
+b(?X) :- c(?X).
+c(?X) :- a(?X).
+`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	_, err = Stratify(program.Rules)
+	want := "rules cannot be stratified: a reads b through not in the cycle a -> not b -> c -> a"
+	if err == nil || err.Error() != want {
+		t.Errorf("Stratify error = %v, want %q", err, want)
+	}
+}
+
+func TestStratifyRejectsAggregateCycle(t *testing.T) {
+	// total(?T) :- aggregate(sum(?D), total(?D), ?T).
+	rules := []Rule{{
+		Head: Atom{Predicate: "total", Args: []Term{Variable("?T")}},
+		Body: []Clause{Aggregation{
+			Op:       AggSum,
+			Variable: Variable("?D"),
+			Body:     []Clause{AtomClause{Atom: Atom{Predicate: "total", Args: []Term{Variable("?D")}}}},
+			Into:     Variable("?T"),
+		}},
+	}}
+
+	_, err := Stratify(rules)
+	want := "rules cannot be stratified: total reads total through aggregate in the cycle total -> aggregate total"
+	if err == nil || err.Error() != want {
+		t.Errorf("Stratify error = %v, want %q", err, want)
+	}
+}
+
+const actions = `
+action(1, "GoCompile", 10, "//a:x").
+action(2, "GoCompile", 30, "//a:y").
+action(3, "CppCompile", 5, "//b:x").
+action(4, "CppCompile", 7, "//b:x").
+action(5, "CppCompile", 9, "//c:z").
+`
+
+func TestAggregate(t *testing.T) {
+	engine := evaluate(t, actions+`
+total(?T) :- aggregate(sum(?D), action(_, _, ?D, _), ?T).
+slow(?N) :- aggregate(count, action(_, _, ?D, _), ?D > 8, ?N).
+none(?N) :- aggregate(count, action(_, _, ?D, _), ?D > 100, ?N).
+longest(?M) :- aggregate(max(?D), action(_, _, ?D, _), ?D > 100, ?M).
+`)
+	assertFacts(t, engine, "total", "total(61)")
+	assertFacts(t, engine, "slow", "slow(3)")
+	assertFacts(t, engine, "none", "none(0)")
+	assertFacts(t, engine, "longest")
+}
+
+func TestAggregateGroupBy(t *testing.T) {
+	engine := evaluate(t, actions+`
+mnemonic_time(?M, ?T) :- aggregate(sum(?D) by ?M, action(_, ?M, ?D, _), ?T).
+target_count(?M, ?T, ?N) :- aggregate(count by (?M, ?T), action(_, ?M, _, ?T), ?N).
+over_limit(?M, ?N) :- aggregate(count by ?M, action(_, ?M, ?D, _), ?D > 100, ?N).
+`)
+	assertFacts(t, engine, "mnemonic_time",
+		`mnemonic_time("GoCompile", 40)`, `mnemonic_time("CppCompile", 21)`)
+	assertFacts(t, engine, "target_count",
+		`target_count("GoCompile", "//a:x", 1)`, `target_count("GoCompile", "//a:y", 1)`,
+		`target_count("CppCompile", "//b:x", 2)`, `target_count("CppCompile", "//c:z", 1)`)
+	// Groups only come from the body's results, so nothing matches nothing
+	assertFacts(t, engine, "over_limit")
+}
+
+func TestAggregateArgMax(t *testing.T) {
+	engine := evaluate(t, actions+`
+slowest(?M, ?Id, ?Target, ?D) :- aggregate(argmax(?Dur, ?Id, ?Target) by ?M, action(?Id, ?M, ?Dur, ?Target), ?D).
+fastest(?Target, ?D) :- aggregate(argmin(?Dur, ?Target), action(_, _, ?Dur, ?Target), ?D).
+`)
+	assertFacts(t, engine, "slowest",
+		`slowest("GoCompile", 2, "//a:y", 30)`, `slowest("CppCompile", 5, "//c:z", 9)`)
+	assertFacts(t, engine, "fastest", `fastest("//b:x", 5)`)
+}
+
+func TestAggregatePercentileAndCollect(t *testing.T) {
+	engine := evaluate(t, actions+`
+p90(?P) :- aggregate(percentile(?D, 90), action(_, _, ?D, _), ?P).
+median(?M, ?P) :- aggregate(median(?D) by ?M, action(_, ?M, ?D, _), ?P).
+spread(?S) :- aggregate(stddev(?D), action(_, "GoCompile", ?D, _), ?S).
+targets(?M, ?N) :- aggregate(count_distinct(?T) by ?M, action(_, ?M, _, ?T), ?N).
+target_list(?M, ?L) :- aggregate(collect(?T) by ?M, action(_, ?M, _, ?T), ?L).
+`)
+	assertFacts(t, engine, "p90", "p90(30)")
+	assertFacts(t, engine, "median", `median("GoCompile", 20)`, `median("CppCompile", 7)`)
+	assertFacts(t, engine, "spread", "spread(10)")
+	assertFacts(t, engine, "targets", `targets("GoCompile", 2)`, `targets("CppCompile", 2)`)
+	assertFacts(t, engine, "target_list",
+		`target_list("GoCompile", [//a:x //a:y])`, `target_list("CppCompile", [//b:x //b:x //c:z])`)
+}
+
+func TestAggregateParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`x(?M) :- aggregate(percentile(?D, 101), action(_, _, ?D, _), ?M).`,
+		`x(?M) :- aggregate(argmax(?D), action(_, _, ?D, _), ?M).`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestByIsNotAKeyword(t *testing.T) {
+	engine := evaluate(t, actions+`
+by(?M, ?T) :- action(_, ?M, _, ?T).
+per_mnemonic(?M, ?N) :- aggregate(count by ?M, by(?M, _), ?N).
+`)
+	assertFacts(t, engine, "per_mnemonic", `per_mnemonic("GoCompile", 2)`, `per_mnemonic("CppCompile", 2)`)
+}
@@ -1,15 +1,124 @@
 package datalog
 
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
 // TraceEvent represents a trace event (mirrored from main package to avoid import cycle)
 type TraceEvent struct {
-	Name string                 `json:"name"`
-	Cat  string                 `json:"cat,omitempty"`
-	Ph   string                 `json:"ph"`
-	Ts   float64                `json:"ts"`
-	Dur  float64                `json:"dur,omitempty"`
-	Pid  int                    `json:"pid,omitempty"`
-	Tid  int                    `json:"tid,omitempty"`
-	Args map[string]interface{} `json:"args,omitempty"`
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Ph    string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur,omitempty"`
+	Pid   int                    `json:"pid,omitempty"`
+	Tid   int                    `json:"tid,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+	Async bool                   `json:"async,omitempty"` // Paired from async (b/e) events, which belong to no thread
+}
+
+// Profile holds the loaded profile data that facts are generated from
+type Profile struct {
+	TraceEvents   []TraceEvent   // Complete spans (ph: "X", including paired B/E and async events)
+	InstantEvents []TraceEvent   // Zero-width markers (ph: "i", "I", "n")
+	CounterEvents []CounterEvent // Resource samples (ph: "C")
+	FlowEdges     []FlowEdge     // Causal links between TraceEvents from flow events
+	Sources       []ProfileSource
+	FunctionCosts []FunctionCost     // Flat per-function totals from the Starlark pprof profile
+	BuildEvents   *BuildEvents       // Outcomes from --bep, nil when not given
+	Spawns        []Spawn            // From --execution_log
+	SpanTree      *SpanTree          // Computed from TraceEvents when nil
+	Utilization   *Utilization       // Computed from TraceEvents when nil
+	Distributions *Distributions     // Computed from TraceEvents when nil
+	Packages      *PackageTimes      // Computed from TraceEvents when nil
+	BuildPhases   []BuildPhase       // Computed from the phase markers when nil
+	CriticalPath  []CriticalPathStep // Computed from TraceEvents when nil
+
+	ConcurrencyBucketUs       float64 // Width of concurrency_bucket facts; 0 splits the build into 100 buckets
+	ConcurrencyActionableOnly bool    // Count only actionable events in concurrency_bucket facts
+
+	ThreadRoles []ThreadRolePattern // From thread_role_pattern facts; without any, every thread is "other"
+}
+
+// FunctionCost is the flat cost of one Starlark function for one pprof sample type
+type FunctionCost struct {
+	Function   string
+	File       string
+	SampleType string
+	Unit       string
+	Value      int64
+}
+
+// ProfileSource names the profile file that owns every event with its pid
+type ProfileSource struct {
+	Name        string
+	Pid         int
+	Invocation  *InvocationInfo // nil when the profile has no otherData
+	ThreadNames map[int]string  // Thread names by tid
+}
+
+// InvocationInfo mirrors main.InvocationInfo (a Bazel profile's otherData)
+type InvocationInfo struct {
+	BuildID      string
+	OutputBase   string
+	Date         string
+	BazelVersion string
+}
+
+// FlowEdge links two trace events by their index in Profile.TraceEvents
+type FlowEdge struct {
+	From int
+	To   int
+}
+
+// Spawn mirrors main.Spawn (one execution log entry joined to its action span)
+type Spawn struct {
+	Event       int // Index into Profile.TraceEvents, -1 if no span matched
+	Runner      string
+	CacheHit    bool
+	InputFiles  int64
+	InputBytes  int64
+	OutputBytes int64
+}
+
+// BuildEvents mirrors main.BuildEvents (target and test outcomes from the BEP)
+type BuildEvents struct {
+	Command           string
+	CommandLine       []string
+	Options           []BuildOption
+	Targets           []TargetOutcome
+	Tests             []TestResult
+	TestSummaries     []TargetOutcome
+	ActionCacheHits   int64
+	ActionCacheMisses int64
+}
+
+// BuildOption is one option from the canonical command line
+type BuildOption struct {
+	Name  string
+	Value string
+}
+
+// TargetOutcome is the final status of a target or test
+type TargetOutcome struct {
+	Target string
+	Status string
+}
+
+// TestResult is a single test attempt
+type TestResult struct {
+	Target         string
+	Run            int
+	Shard          int
+	Attempt        int
+	Status         string
+	DurUs          float64
+	CachedLocally  bool
+	CachedRemotely bool
 }
 
 // isActionableCategory returns true if the category represents user-controlled work
@@ -38,14 +147,58 @@ func isSystemCategory(cat string) bool {
 	}
 }
 
-// GenerateFacts generates Datalog facts from trace events
-func GenerateFacts(events []TraceEvent) []Fact {
+// isActionableEvent returns true if the event is user-controlled work:
+// 1. It has a target label (user's BUILD files), OR
+// 2. It's in an actionable category AND has a mnemonic
+func isActionableEvent(e TraceEvent) bool {
+	if target, ok := e.Args["target"].(string); ok && target != "" {
+		return true
+	}
+	return isActionableCategory(e.Cat) && e.Args["mnemonic"] != nil
+}
+
+// GenerateFacts generates Datalog facts from a loaded profile
+func GenerateFacts(profile *Profile) []Fact {
+	events := profile.TraceEvents
 	facts := make([]Fact, 0, len(events)*4)
 
+	sourceByPid := make(map[int]string, len(profile.Sources))
+	for _, source := range profile.Sources {
+		sourceByPid[source.Pid] = source.Name
+	}
+
+	tree := profile.SpanTree
+	if tree == nil {
+		tree = ComputeSpanTree(events)
+	}
+
+	utilization := profile.Utilization
+	if utilization == nil {
+		utilization = ComputeUtilization(profile)
+	}
+
+	phases := profile.BuildPhases
+	if phases == nil {
+		phases = ComputeBuildPhases(profile)
+	}
+
+	criticalPath := profile.CriticalPath
+	if criticalPath == nil {
+		criticalPath = ComputeCriticalPathSteps(events)
+	}
+
+	// Invocation metadata from each profile's otherData
+	for _, source := range profile.Sources {
+		if source.Invocation != nil {
+			facts = append(facts, generateInvocationFacts(source.Name, source.Invocation)...)
+		}
+	}
+
 	var totalDuration float64
 	var maxEnd float64
 	var actionableTime float64
 	var actionableCount int
+	var execStart, execEnd float64 // Window in which actionable events ran
 
 	// First pass: compute totals and generate base facts
 	for i, e := range events {
@@ -67,6 +220,14 @@ func GenerateFacts(events []TraceEvent) []Fact {
 			Args:      []interface{}{i, e.Pid},
 		})
 
+		// trace_event_source(id, source_name)
+		if source, ok := sourceByPid[e.Pid]; ok {
+			facts = append(facts, Fact{
+				Predicate: "trace_event_source",
+				Args:      []interface{}{i, source},
+			})
+		}
+
 		// Extract mnemonic from args
 		if mnemonic, ok := e.Args["mnemonic"].(string); ok {
 			facts = append(facts, Fact{
@@ -88,24 +249,55 @@ func GenerateFacts(events []TraceEvent) []Fact {
 			})
 		}
 
-		// Determine if event is actionable (user-controlled) vs system (Bazel infra)
-		hasTarget := false
-		if target, ok := e.Args["target"].(string); ok && target != "" {
-			hasTarget = true
+		// trace_event_parent(child_id, parent_id) for spans nested on the same thread
+		if parent := tree.Parent[i]; parent >= 0 {
+			facts = append(facts, Fact{
+				Predicate: "trace_event_parent",
+				Args:      []interface{}{i, parent},
+			})
+		}
+
+		// trace_event_depth(id, depth)
+		facts = append(facts, Fact{
+			Predicate: "trace_event_depth",
+			Args:      []interface{}{i, tree.Depth[i]},
+		})
+
+		// trace_event_self(id, self_us)
+		facts = append(facts, Fact{
+			Predicate: "trace_event_self",
+			Args:      []interface{}{i, tree.SelfUs[i]},
+		})
+
+		// trace_event_phase(id, phase) for spans that start after the first marker
+		if phase, ok := phaseAt(phases, e.Pid, e.Ts); ok {
+			facts = append(facts, Fact{
+				Predicate: "trace_event_phase",
+				Args:      []interface{}{i, phase},
+			})
 		}
 
-		// An event is actionable if:
-		// 1. It has a target label (user's BUILD files), OR
-		// 2. It's in an actionable category AND has a mnemonic
-		isActionable := hasTarget || (isActionableCategory(e.Cat) && e.Args["mnemonic"] != nil)
+		// trace_event_arg(id, key, value) for every scalar arg
+		flattenArgs("", e.Args, func(key string, value interface{}) {
+			facts = append(facts, Fact{
+				Predicate: "trace_event_arg",
+				Args:      []interface{}{i, key, value},
+			})
+		})
 
-		if isActionable {
+		// Determine if event is actionable (user-controlled) vs system (Bazel infra).
+		// Critical path components repeat action spans; see critical_path_step.
+		if isActionableEvent(e) {
 			facts = append(facts, Fact{
 				Predicate: "is_actionable",
 				Args:      []interface{}{i},
 			})
 			actionableTime += e.Dur
 			actionableCount++
+			if actionableCount == 1 || e.Ts < execStart {
+				execStart = e.Ts
+			}
+			execEnd = math.Max(execEnd, e.Ts+e.Dur)
 		}
 
 		if isSystemCategory(e.Cat) {
@@ -199,6 +391,24 @@ func GenerateFacts(events []TraceEvent) []Fact {
 		})
 	}
 
+	// Duration distributions per mnemonic, target package and category
+	distributions := profile.Distributions
+	if distributions == nil {
+		distributions = ComputeDistributions(events)
+	}
+	facts = append(facts, generateDistributionFacts("mnemonic", distributions.Mnemonic)...)
+	facts = append(facts, generateDistributionFacts("package", distributions.Package)...)
+	facts = append(facts, generateDistributionFacts("category", distributions.Category)...)
+
+	// Actions much slower than their peers (same mnemonic and repository)
+	for _, o := range ComputeDurationOutliers(events) {
+		// duration_outlier(id, mnemonic, duration_us, score)
+		facts = append(facts, Fact{
+			Predicate: "duration_outlier",
+			Args:      []interface{}{o.Event, o.Mnemonic, o.DurUs, o.Score},
+		})
+	}
+
 	// Compute target-based aggregates (by Bazel package)
 	targetTime := make(map[string]float64)
 	targetCount := make(map[string]int)
@@ -215,120 +425,389 @@ func GenerateFacts(events []TraceEvent) []Fact {
 			Predicate: "target_time",
 			Args:      []interface{}{target, time},
 		})
+
+		if label, err := ParseLabel(target); err == nil && !label.Relative {
+			// target_package(target, package), target_repo(target, repo)
+			facts = append(facts, Fact{
+				Predicate: "target_package",
+				Args:      []interface{}{target, label.PackageLabel()},
+			})
+			facts = append(facts, Fact{
+				Predicate: "target_repo",
+				Args:      []interface{}{target, label.RepoName()},
+			})
+		}
 	}
 
+	// Target time rolled up by package (including subpackages) and repository
+	packages := profile.Packages
+	if packages == nil {
+		packages = ComputePackageTimes(events)
+	}
+	facts = append(facts, generatePackageFacts(packages)...)
+
 	// Compute concurrency (max overlapping events)
-	maxConcurrency := computeMaxConcurrency(events)
+	steps := ComputeConcurrency(events, nil)
 	facts = append(facts, Fact{
 		Predicate: "max_concurrency",
-		Args:      []interface{}{maxConcurrency},
+		Args:      []interface{}{maxConcurrency(steps)},
 	})
 
+	// Concurrency over time, optionally counting actionable events only
+	if profile.ConcurrencyActionableOnly {
+		steps = ComputeConcurrency(events, func(i int) bool {
+			return isActionableEvent(events[i])
+		})
+	}
+	facts = append(facts, generateConcurrencyFacts(steps, profile.ConcurrencyBucketUs)...)
+
 	// Compute critical path info
-	criticalPathFacts := computeCriticalPath(events)
+	criticalPathFacts := computeCriticalPath(events, criticalPath)
 	facts = append(facts, criticalPathFacts...)
 
+	// Thread busy time and idle stretches
+	for _, t := range utilization.Threads {
+		// thread_utilization(pid, tid, thread_name, busy_pct)
+		facts = append(facts, Fact{
+			Predicate: "thread_utilization",
+			Args:      []interface{}{t.Pid, t.Tid, t.Name, t.BusyPct},
+		})
+
+		for _, gap := range t.IdleGaps {
+			// idle_gap(pid, tid, start_us, duration_us)
+			facts = append(facts, Fact{
+				Predicate: "idle_gap",
+				Args:      []interface{}{t.Pid, t.Tid, gap.StartUs, gap.DurUs},
+			})
+		}
+	}
+
+	// Build phases from the phase markers
+	for _, p := range phases {
+		// build_phase(pid, name, start_us, duration_us)
+		facts = append(facts, Fact{
+			Predicate: "build_phase",
+			Args:      []interface{}{p.Pid, p.Name, p.StartUs, p.DurUs},
+		})
+	}
+
+	// Thread roles from thread names, with busy and self time per role
+	facts = append(facts, generateThreadRoleFacts(profile, utilization, tree)...)
+
+	// Counter samples (CPU, memory, load, ...) and their summaries
+	facts = append(facts, generateCounterFacts(profile.CounterEvents, execStart, execEnd)...)
+
+	// Flow edges (producer -> consumer)
+	for _, edge := range profile.FlowEdges {
+		// trace_flow(from_event_id, to_event_id)
+		facts = append(facts, Fact{
+			Predicate: "trace_flow",
+			Args:      []interface{}{edge.From, edge.To},
+		})
+	}
+
+	// Starlark function costs, one per pprof sample type
+	sampleUnits := make(map[string]string)
+	var sampleTypes []string
+	for _, c := range profile.FunctionCosts {
+		// starlark_function_cost(function, file, sample_type, value)
+		facts = append(facts, Fact{
+			Predicate: "starlark_function_cost",
+			Args:      []interface{}{c.Function, c.File, c.SampleType, c.Value},
+		})
+		if _, ok := sampleUnits[c.SampleType]; !ok {
+			sampleUnits[c.SampleType] = c.Unit
+			sampleTypes = append(sampleTypes, c.SampleType)
+		}
+	}
+	for _, st := range sampleTypes {
+		// starlark_sample_type(sample_type, unit)
+		facts = append(facts, Fact{
+			Predicate: "starlark_sample_type",
+			Args:      []interface{}{st, sampleUnits[st]},
+		})
+	}
+
+	// Instant markers (zero-width events)
+	for i, e := range profile.InstantEvents {
+		// instant_event(id, name, category, ts_us)
+		facts = append(facts, Fact{
+			Predicate: "instant_event",
+			Args:      []interface{}{i, e.Name, e.Cat, e.Ts},
+		})
+	}
+
+	// Execution log details for spawns that matched an action span
+	for _, s := range profile.Spawns {
+		if s.Event < 0 {
+			continue
+		}
+
+		// spawn_runner(event_id, runner)
+		facts = append(facts, Fact{
+			Predicate: "spawn_runner",
+			Args:      []interface{}{s.Event, s.Runner},
+		})
+
+		// spawn_cache_hit(event_id)
+		if s.CacheHit {
+			facts = append(facts, Fact{
+				Predicate: "spawn_cache_hit",
+				Args:      []interface{}{s.Event},
+			})
+		}
+
+		// spawn_input_files(event_id, count) / spawn_input_bytes(event_id, bytes)
+		facts = append(facts, Fact{
+			Predicate: "spawn_input_files",
+			Args:      []interface{}{s.Event, s.InputFiles},
+		})
+		facts = append(facts, Fact{
+			Predicate: "spawn_input_bytes",
+			Args:      []interface{}{s.Event, s.InputBytes},
+		})
+
+		// spawn_output_bytes(event_id, bytes)
+		facts = append(facts, Fact{
+			Predicate: "spawn_output_bytes",
+			Args:      []interface{}{s.Event, s.OutputBytes},
+		})
+	}
+
+	if profile.BuildEvents != nil {
+		facts = append(facts, generateBuildEventFacts(profile.BuildEvents)...)
+	}
+
 	return facts
 }
 
-// computeMaxConcurrency computes the maximum number of concurrent events
-func computeMaxConcurrency(events []TraceEvent) int {
-	if len(events) == 0 {
-		return 0
+// bazelReleasePattern matches the version number in e.g. "release 7.1.0rc2"
+var bazelReleasePattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// generateInvocationFacts generates facts describing the invocation a profile
+// was recorded for
+func generateInvocationFacts(source string, info *InvocationInfo) []Fact {
+	facts := []Fact{
+		// invocation(build_id, date, bazel_version)
+		{
+			Predicate: "invocation",
+			Args:      []interface{}{info.BuildID, info.Date, info.BazelVersion},
+		},
+		// invocation_source(build_id, source_name)
+		{
+			Predicate: "invocation_source",
+			Args:      []interface{}{info.BuildID, source},
+		},
 	}
 
-	// Create a list of start and end times
-	type timePoint struct {
-		time    float64
-		isStart bool
+	if info.OutputBase != "" {
+		// invocation_output_base(build_id, output_base)
+		facts = append(facts, Fact{
+			Predicate: "invocation_output_base",
+			Args:      []interface{}{info.BuildID, info.OutputBase},
+		})
 	}
 
-	points := make([]timePoint, 0, len(events)*2)
-	for _, e := range events {
-		points = append(points, timePoint{e.Ts, true})
-		points = append(points, timePoint{e.Ts + e.Dur, false})
+	// bazel_release(build_id, major, minor, patch) for comparing versions in rules
+	if m := bazelReleasePattern.FindStringSubmatch(info.BazelVersion); m != nil {
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		patch, _ := strconv.Atoi(m[3])
+		facts = append(facts, Fact{
+			Predicate: "bazel_release",
+			Args:      []interface{}{info.BuildID, major, minor, patch},
+		})
 	}
 
-	// Sort by time, with starts before ends at the same time
-	for i := 0; i < len(points)-1; i++ {
-		for j := i + 1; j < len(points); j++ {
-			if points[i].time > points[j].time ||
-				(points[i].time == points[j].time && !points[i].isStart && points[j].isStart) {
-				points[i], points[j] = points[j], points[i]
-			}
-		}
+	return facts
+}
+
+// generateBuildEventFacts generates facts for target and test outcomes, cache
+// hits and the command line from the Build Event Protocol
+func generateBuildEventFacts(b *BuildEvents) []Fact {
+	var facts []Fact
+
+	if b.Command != "" {
+		// build_command(command)
+		facts = append(facts, Fact{
+			Predicate: "build_command",
+			Args:      []interface{}{b.Command},
+		})
 	}
 
-	// Sweep through and track max concurrent
-	maxConcurrent := 0
-	current := 0
-	for _, p := range points {
-		if p.isStart {
-			current++
-			if current > maxConcurrent {
-				maxConcurrent = current
-			}
-		} else {
-			current--
+	for i, arg := range b.CommandLine {
+		// command_line_arg(index, arg)
+		facts = append(facts, Fact{
+			Predicate: "command_line_arg",
+			Args:      []interface{}{i, arg},
+		})
+	}
+
+	for _, o := range b.Options {
+		// build_option(name, value)
+		facts = append(facts, Fact{
+			Predicate: "build_option",
+			Args:      []interface{}{o.Name, o.Value},
+		})
+	}
+
+	for _, t := range b.Targets {
+		// target_outcome(target, status)
+		facts = append(facts, Fact{
+			Predicate: "target_outcome",
+			Args:      []interface{}{t.Target, t.Status},
+		})
+	}
+
+	for _, t := range b.Tests {
+		// test_result(target, shard, attempt, status, dur_us)
+		facts = append(facts, Fact{
+			Predicate: "test_result",
+			Args:      []interface{}{t.Target, t.Shard, t.Attempt, t.Status, t.DurUs},
+		})
+
+		// test_cached(target, shard, attempt, where)
+		if t.CachedLocally {
+			facts = append(facts, Fact{
+				Predicate: "test_cached",
+				Args:      []interface{}{t.Target, t.Shard, t.Attempt, "local"},
+			})
+		}
+		if t.CachedRemotely {
+			facts = append(facts, Fact{
+				Predicate: "test_cached",
+				Args:      []interface{}{t.Target, t.Shard, t.Attempt, "remote"},
+			})
 		}
 	}
 
-	return maxConcurrent
+	for _, t := range b.TestSummaries {
+		// test_summary(target, overall_status)
+		facts = append(facts, Fact{
+			Predicate: "test_summary",
+			Args:      []interface{}{t.Target, t.Status},
+		})
+	}
+
+	// action_cache_stats(hits, misses)
+	facts = append(facts, Fact{
+		Predicate: "action_cache_stats",
+		Args:      []interface{}{b.ActionCacheHits, b.ActionCacheMisses},
+	})
+
+	return facts
 }
 
-// computeCriticalPath identifies events on the critical path
-func computeCriticalPath(events []TraceEvent) []Fact {
+// generateConcurrencyFacts summarizes the concurrency step function into
+// buckets of bucketUs (or a fixed number of buckets when bucketUs is 0)
+func generateConcurrencyFacts(steps []ConcurrencyStep, bucketUs float64) []Fact {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	start, end := steps[0].Ts, steps[len(steps)-1].Ts
+	if bucketUs <= 0 {
+		bucketUs = (end - start) / defaultConcurrencyBuckets
+	}
+
+	var facts []Fact
+	facts = append(facts, Fact{
+		Predicate: "concurrency_resolution",
+		Args:      []interface{}{bucketUs},
+	})
+	for _, b := range ConcurrencyBuckets(steps, start, end, bucketUs) {
+		// concurrency_bucket(bucket_start_us, avg_concurrent, max_concurrent)
+		facts = append(facts, Fact{
+			Predicate: "concurrency_bucket",
+			Args:      []interface{}{b.StartUs, b.Avg, b.Max},
+		})
+	}
+	return facts
+}
+
+// computeCriticalPath identifies events on the critical path of each profile
+// source, given the steps Bazel reported
+func computeCriticalPath(events []TraceEvent, steps []CriticalPathStep) []Fact {
 	if len(events) == 0 {
 		return nil
 	}
 
 	var facts []Fact
 
-	// Find the max end time for all events
+	// Find the max end time for all events, and per pid
 	var maxEnd float64
+	pidEnd := make(map[int]float64)
 	for _, e := range events {
 		end := e.Ts + e.Dur
 		if end > maxEnd {
 			maxEnd = end
 		}
+		if end > pidEnd[e.Pid] {
+			pidEnd[e.Pid] = end
+		}
 	}
 
-	// Find the actionable event that ends last (critical path endpoint)
-	var lastActionableEvent *TraceEvent
-	var lastActionableEventIdx int
-	var lastActionableEnd float64
-
+	// Prefer the critical path Bazel computed; for a pid without one, fall back
+	// to its actionable event that ends last as a one-step path
+	hasPath := make(map[int]bool)
+	for _, step := range steps {
+		hasPath[step.Pid] = true
+	}
+	lastEnd := make(map[int]int)
 	for i, e := range events {
 		// Only consider actionable events (those with targets)
-		if target, ok := e.Args["target"].(string); ok && target != "" {
-			end := e.Ts + e.Dur
-			if end > lastActionableEnd {
-				lastActionableEnd = end
-				lastActionableEvent = &events[i]
-				lastActionableEventIdx = i
+		if target, ok := e.Args["target"].(string); ok && target != "" && !hasPath[e.Pid] {
+			if last, ok := lastEnd[e.Pid]; !ok || e.Ts+e.Dur > events[last].Ts+events[last].Dur {
+				lastEnd[e.Pid] = i
 			}
 		}
 	}
+	if len(lastEnd) > 0 {
+		steps = append([]CriticalPathStep(nil), steps...)
+		for _, i := range lastEnd {
+			e := events[i]
+			target, _ := e.Args["target"].(string)
+			mnemonic, _ := e.Args["mnemonic"].(string)
+			steps = append(steps, CriticalPathStep{Pid: e.Pid, Event: i, Component: -1, Name: e.Name, Target: target, Mnemonic: mnemonic, Ts: e.Ts, DurUs: e.Dur})
+		}
+		sort.SliceStable(steps, func(a, b int) bool { return steps[a].Pid < steps[b].Pid })
+	}
 
-	if lastActionableEvent != nil {
-		target := ""
-		if t, ok := lastActionableEvent.Args["target"].(string); ok {
-			target = t
+	for start := 0; start < len(steps); {
+		end := start
+		var pathDuration float64
+		for ; end < len(steps) && steps[end].Pid == steps[start].Pid; end++ {
+			step := steps[end]
+			// critical_path_step(pid, index, event_id, target, mnemonic, duration_us)
+			facts = append(facts, Fact{
+				Predicate: "critical_path_step",
+				Args:      []interface{}{step.Pid, step.Index, step.Event, step.Target, step.Mnemonic, step.DurUs},
+			})
+			pathDuration += step.DurUs
 		}
+
+		pid := steps[start].Pid
+		last := steps[end-1]
 		// Mark as critical path endpoint
 		facts = append(facts, Fact{
 			Predicate: "critical_path_end",
-			Args:      []interface{}{lastActionableEventIdx, lastActionableEvent.Name, lastActionableEvent.Dur, target},
+			Args:      []interface{}{last.Event, events[last.Event].Name, last.DurUs, last.Target},
 		})
 
-		// Calculate critical path percentage
-		if maxEnd > 0 {
-			criticalPathPct := (lastActionableEvent.Dur / maxEnd) * 100
+		facts = append(facts, Fact{
+			Predicate: "critical_path_duration",
+			Args:      []interface{}{pid, pathDuration},
+		})
+
+		// Calculate critical path percentage of the pid's build time
+		if pidEnd[pid] > 0 {
+			criticalPathPct := (pathDuration / pidEnd[pid]) * 100
 			facts = append(facts, Fact{
 				Predicate: "critical_path_percent",
-				Args:      []interface{}{criticalPathPct},
+				Args:      []interface{}{pid, criticalPathPct},
 			})
 		}
+		start = end
 	}
 
 	// Find top bottlenecks among actionable events only
@@ -393,3 +872,36 @@ func GenerateEventPercentFacts(events []TraceEvent, totalDuration float64) []Fac
 
 	return facts
 }
+
+// flattenArgs calls emit for every scalar in args. Nested maps are joined with
+// "." (e.g. "digest.hash") and list elements are keyed by index ("inputs.0").
+// Booleans become the strings "true" and "false".
+func flattenArgs(prefix string, args map[string]interface{}, emit func(key string, value interface{})) {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		flattenArg(prefix+k, args[k], emit)
+	}
+}
+
+func flattenArg(key string, value interface{}, emit func(key string, value interface{})) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		flattenArgs(key+".", v, emit)
+	case []interface{}:
+		for i, item := range v {
+			flattenArg(fmt.Sprintf("%s.%d", key, i), item, emit)
+		}
+	case bool:
+		emit(key, fmt.Sprint(v))
+	case string, float64, int, int64:
+		emit(key, v)
+	default:
+		emit(key, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,131 @@
+package datalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Label is a parsed Bazel label
+type Label struct {
+	Repo      string // Repository name without "@", "" for the main repository
+	Canonical bool   // Written with "@@" (e.g. "@@rules_go~0.46.0~go_sdk")
+	Package   string // Package path without "//", "" for the root package
+	Name      string // Target name; may contain "/" for file targets
+	Relative  bool   // No "//" (":name" or "name"), so Package is unknown
+}
+
+// ParseLabel parses the label forms Bazel writes and accepts on the command line:
+//
+//	//pkg:name, //pkg (name is the last package component), @repo//pkg:name,
+//	@@canonical~repo//pkg:name, @repo (short for @repo//:repo), :name and name
+//
+// A relative label without ":" (e.g. "src/main.cc") is taken as a file target.
+func ParseLabel(s string) (Label, error) {
+	var l Label
+	rest := s
+
+	if strings.HasPrefix(rest, "@") {
+		rest = rest[1:]
+		if strings.HasPrefix(rest, "@") {
+			l.Canonical = true
+			rest = rest[1:]
+		}
+		end := strings.Index(rest, "//")
+		if end < 0 {
+			// @repo is short for @repo//:repo
+			if rest == "" || strings.ContainsAny(rest, ":/") {
+				return Label{}, fmt.Errorf("invalid label %q: expected // after repository", s)
+			}
+			l.Repo, l.Name = rest, rest
+			return l, nil
+		}
+		l.Repo, rest = rest[:end], rest[end:]
+		if strings.ContainsAny(l.Repo, ":/") {
+			return Label{}, fmt.Errorf("invalid label %q: bad repository name", s)
+		}
+	}
+
+	if !strings.HasPrefix(rest, "//") {
+		if l.Repo != "" || l.Canonical {
+			return Label{}, fmt.Errorf("invalid label %q: expected // after repository", s)
+		}
+		l.Relative = true
+		l.Name = strings.TrimPrefix(rest, ":")
+		if l.Name == "" {
+			return Label{}, fmt.Errorf("invalid label %q: empty target name", s)
+		}
+		return l, nil
+	}
+
+	rest = rest[2:]
+	if i := strings.Index(rest, ":"); i >= 0 {
+		l.Package, l.Name = rest[:i], rest[i+1:]
+		if l.Name == "" {
+			return Label{}, fmt.Errorf("invalid label %q: empty target name", s)
+		}
+	} else {
+		// //pkg/foo is short for //pkg/foo:foo
+		l.Package = rest
+		l.Name = rest[strings.LastIndex(rest, "/")+1:]
+		if l.Name == "" {
+			return Label{}, fmt.Errorf("invalid label %q: empty target name", s)
+		}
+	}
+	if strings.HasPrefix(l.Package, "/") || strings.HasSuffix(l.Package, "/") || strings.Contains(l.Package, "//") {
+		return Label{}, fmt.Errorf("invalid label %q: bad package name", s)
+	}
+	return l, nil
+}
+
+// RepoName returns the repository as written in labels: "@repo", "@@repo",
+// or "@" for the main repository
+func (l Label) RepoName() string {
+	if l.Canonical {
+		return "@@" + l.Repo
+	}
+	return "@" + l.Repo
+}
+
+// PackageLabel returns the package as a label prefix: "//pkg" in the main
+// repository, "@repo//pkg" elsewhere. It is "" for relative labels.
+func (l Label) PackageLabel() string {
+	if l.Relative {
+		return ""
+	}
+	return packageLabel(l, l.Package)
+}
+
+// ParentPackages returns the enclosing packages of the label's package,
+// innermost first and ending with the repository root ("//a/b" -> "//a", "//")
+func (l Label) ParentPackages() []string {
+	if l.Relative || l.Package == "" {
+		return nil
+	}
+	var parents []string
+	pkg := l.Package
+	for pkg != "" {
+		i := strings.LastIndex(pkg, "/")
+		if i < 0 {
+			pkg = ""
+		} else {
+			pkg = pkg[:i]
+		}
+		parents = append(parents, packageLabel(l, pkg))
+	}
+	return parents
+}
+
+// String formats the label in its canonical written form
+func (l Label) String() string {
+	if l.Relative {
+		return ":" + l.Name
+	}
+	return l.PackageLabel() + ":" + l.Name
+}
+
+func packageLabel(l Label, pkg string) string {
+	if l.Repo == "" && !l.Canonical {
+		return "//" + pkg
+	}
+	return l.RepoName() + "//" + pkg
+}
@@ -0,0 +1,110 @@
+package datalog
+
+import (
+	"math"
+	"sort"
+)
+
+// outlierScoreThreshold is the modified z-score above which an action counts
+// as an outlier (Iglewicz and Hoaglin's recommended cut-off)
+const outlierScoreThreshold = 3.5
+
+// minOutlierPeers is the smallest peer group in which outliers are looked for
+const minOutlierPeers = 10
+
+// DurationOutlier is an action much slower than its peers
+type DurationOutlier struct {
+	Event    int
+	Mnemonic string
+	DurUs    float64
+	Score    float64 // Modified z-score against the peer group
+}
+
+// outlierPeers groups actions with the same mnemonic from the same repository,
+// so external compiles are not compared with the main repository's
+type outlierPeers struct {
+	mnemonic, repo string
+}
+
+// ComputeDurationOutliers scores every action against the actions with the same
+// mnemonic and repository using the median absolute deviation (MAD), which a
+// single pathological action cannot inflate the way it inflates a stddev.
+// Only unusually slow actions are reported.
+func ComputeDurationOutliers(events []TraceEvent) []DurationOutlier {
+	groups := make(map[outlierPeers][]int)
+	for i, e := range events {
+		mnemonic, ok := e.Args["mnemonic"].(string)
+		if !ok || mnemonic == "" || e.Cat == criticalPathCategory {
+			continue
+		}
+		key := outlierPeers{mnemonic: mnemonic}
+		if target, ok := e.Args["target"].(string); ok {
+			if label, err := ParseLabel(target); err == nil {
+				key.repo = label.RepoName()
+			}
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	var outliers []DurationOutlier
+	for key, ids := range groups {
+		if len(ids) < minOutlierPeers {
+			continue
+		}
+
+		durations := make([]float64, len(ids))
+		for j, id := range ids {
+			durations[j] = events[id].Dur
+		}
+		median, scale := robustScale(durations)
+		if scale == 0 {
+			continue
+		}
+
+		for _, id := range ids {
+			score := (events[id].Dur - median) / scale
+			if score > outlierScoreThreshold {
+				outliers = append(outliers, DurationOutlier{
+					Event:    id,
+					Mnemonic: key.mnemonic,
+					DurUs:    events[id].Dur,
+					Score:    score,
+				})
+			}
+		}
+	}
+
+	sort.Slice(outliers, func(a, b int) bool { return outliers[a].Event < outliers[b].Event })
+	return outliers
+}
+
+// robustScale returns the median of values and the spread a modified z-score
+// divides by: MAD / 0.6745, or 1.2533 times the mean absolute deviation when
+// more than half the values are equal and the MAD is 0
+func robustScale(values []float64) (median, scale float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median = medianSorted(sorted)
+
+	deviations := make([]float64, len(sorted))
+	var sum float64
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+		sum += deviations[i]
+	}
+	sort.Float64s(deviations)
+
+	if mad := medianSorted(deviations); mad > 0 {
+		return median, mad / 0.6745
+	}
+	return median, 1.2533 * sum / float64(len(deviations))
+}
+
+// medianSorted returns the median of sorted values, averaging the middle pair
+func medianSorted(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
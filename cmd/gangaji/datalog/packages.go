@@ -0,0 +1,117 @@
+package datalog
+
+import "sort"
+
+// PackageTimes rolls the time of targets up the package hierarchy and into
+// their repositories
+type PackageTimes struct {
+	Packages map[string]*PackageTime `json:"packages"` // Keyed by package label ("//a/b", "@repo//a")
+	Repos    map[string]float64      `json:"repos"`    // Keyed by repository ("@" is the main repository)
+}
+
+// PackageTime is the time spent on targets in one package and its subpackages
+type PackageTime struct {
+	Parent  string  `json:"parent"` // Enclosing package, "" for repository roots
+	Repo    string  `json:"repo"`
+	TotalUs float64 `json:"totalUs"` // Including subpackages
+	SelfUs  float64 `json:"selfUs"`  // Targets of this package only
+	Targets int     `json:"targets"` // Distinct targets of this package
+}
+
+// ComputePackageTimes sums the duration of every event with a target into its
+// package, each enclosing package and its repository. Targets that are not
+// absolute labels are skipped.
+func ComputePackageTimes(events []TraceEvent) *PackageTimes {
+	result := &PackageTimes{
+		Packages: make(map[string]*PackageTime),
+		Repos:    make(map[string]float64),
+	}
+
+	labels := make(map[string]Label)
+	for _, e := range events {
+		target, ok := e.Args["target"].(string)
+		if !ok || target == "" {
+			continue
+		}
+		label, seen := labels[target]
+		if !seen {
+			parsed, err := ParseLabel(target)
+			if err != nil || parsed.Relative {
+				continue
+			}
+			label = parsed
+			labels[target] = label
+		}
+
+		repo := label.RepoName()
+		result.Repos[repo] += e.Dur
+
+		pkg := label.PackageLabel()
+		self := result.pkg(pkg, repo)
+		self.SelfUs += e.Dur
+		if !seen {
+			self.Targets++
+		}
+
+		// Every enclosing package, up to the repository root
+		child := self
+		for _, parent := range label.ParentPackages() {
+			child.Parent = parent
+			child.TotalUs += e.Dur
+			child = result.pkg(parent, repo)
+		}
+		child.TotalUs += e.Dur
+	}
+
+	return result
+}
+
+// pkg returns the entry of a package, creating it if needed
+func (p *PackageTimes) pkg(name, repo string) *PackageTime {
+	entry, ok := p.Packages[name]
+	if !ok {
+		entry = &PackageTime{Repo: repo}
+		p.Packages[name] = entry
+	}
+	return entry
+}
+
+// generatePackageFacts emits package_time, package_parent and repo_time facts
+func generatePackageFacts(packages *PackageTimes) []Fact {
+	names := make([]string, 0, len(packages.Packages))
+	for name := range packages.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var facts []Fact
+	for _, name := range names {
+		p := packages.Packages[name]
+		// package_time(package, total_us) including subpackages
+		facts = append(facts, Fact{
+			Predicate: "package_time",
+			Args:      []interface{}{name, p.TotalUs},
+		})
+		if p.Parent != "" {
+			// package_parent(package, parent)
+			facts = append(facts, Fact{
+				Predicate: "package_parent",
+				Args:      []interface{}{name, p.Parent},
+			})
+		}
+	}
+
+	repos := make([]string, 0, len(packages.Repos))
+	for repo := range packages.Repos {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		// repo_time(repo, total_us)
+		facts = append(facts, Fact{
+			Predicate: "repo_time",
+			Args:      []interface{}{repo, packages.Repos[repo]},
+		})
+	}
+	return facts
+}
@@ -434,7 +434,8 @@ func (p *Parser) parseAggregation() (Aggregation, error) {
 		return Aggregation{}, err
 	}
 
-	// Parse aggregate operation (count, sum, max, min, avg)
+	// Parse aggregate operation (count, sum, max, min, avg, ...). Names other
+	// than those five are not keywords and stay usable as predicate names.
 	opTok := p.advance()
 	op, err := tokenToAggregateOp(opTok.Type)
 	if err != nil {
@@ -451,6 +452,20 @@ func (p *Parser) parseAggregation() (Aggregation, error) {
 				op = AggMin
 			case "avg":
 				op = AggAvg
+			case "percentile":
+				op = AggPercentile
+			case "median":
+				op = AggMedian
+			case "stddev":
+				op = AggStddev
+			case "count_distinct":
+				op = AggCountDistinct
+			case "argmax":
+				op = AggArgMax
+			case "argmin":
+				op = AggArgMin
+			case "collect":
+				op = AggCollect
 			default:
 				return Aggregation{}, fmt.Errorf("unknown aggregate operation: %s", opTok.Value)
 			}
@@ -460,6 +475,8 @@ func (p *Parser) parseAggregation() (Aggregation, error) {
 	}
 
 	var aggVar Variable
+	var param float64
+	var witnesses []Variable
 
 	// For count, no variable needed; for others, parse variable
 	if op != AggCount {
@@ -471,23 +488,82 @@ func (p *Parser) parseAggregation() (Aggregation, error) {
 			return Aggregation{}, err
 		}
 		aggVar = Variable(varTok.Value)
+
+		switch op {
+		case AggPercentile:
+			// percentile(?X, P) with P in 0-100
+			if _, err := p.expect(TokenComma); err != nil {
+				return Aggregation{}, err
+			}
+			numTok, err := p.expect(TokenNumber)
+			if err != nil {
+				return Aggregation{}, err
+			}
+			param, err = strconv.ParseFloat(numTok.Value, 64)
+			if err != nil || param < 0 || param > 100 {
+				return Aggregation{}, fmt.Errorf("percentile must be between 0 and 100, got %s at %d:%d", numTok.Value, numTok.Line, numTok.Column)
+			}
+		case AggArgMax, AggArgMin:
+			// argmax(?X, ?Witness, ...) needs at least one witness
+			for p.match(TokenComma) {
+				witnessTok, err := p.expect(TokenVariable)
+				if err != nil {
+					return Aggregation{}, err
+				}
+				witnesses = append(witnesses, Variable(witnessTok.Value))
+			}
+			if len(witnesses) == 0 {
+				return Aggregation{}, fmt.Errorf("%s needs a witness variable after %s at %d:%d", op, aggVar, varTok.Line, varTok.Column)
+			}
+		}
+
 		if _, err := p.expect(TokenRParen); err != nil {
 			return Aggregation{}, err
 		}
 	}
 
-	if _, err := p.expect(TokenComma); err != nil {
-		return Aggregation{}, err
+	// Optional group variables: by ?X or by (?X, ?Y). "by" is not a keyword,
+	// so it stays usable as a predicate name elsewhere.
+	var groupBy []Variable
+	if byTok := p.peek(); byTok.Type == TokenIdent && byTok.Value == "by" {
+		p.advance()
+		parens := p.match(TokenLParen)
+		for {
+			varTok, err := p.expect(TokenVariable)
+			if err != nil {
+				return Aggregation{}, err
+			}
+			groupBy = append(groupBy, Variable(varTok.Value))
+			if !parens || !p.match(TokenComma) {
+				break
+			}
+		}
+		if parens {
+			if _, err := p.expect(TokenRParen); err != nil {
+				return Aggregation{}, err
+			}
+		}
 	}
 
-	// Parse body clauses
-	body, err := p.parseBody()
-	if err != nil {
+	if _, err := p.expect(TokenComma); err != nil {
 		return Aggregation{}, err
 	}
 
-	if _, err := p.expect(TokenComma); err != nil {
-		return Aggregation{}, err
+	// Parse body clauses up to the trailing ", ?Result)"
+	var body []Clause
+	for {
+		clause, err := p.parseClause()
+		if err != nil {
+			return Aggregation{}, err
+		}
+		body = append(body, clause)
+
+		if _, err := p.expect(TokenComma); err != nil {
+			return Aggregation{}, err
+		}
+		if p.peek().Type == TokenVariable && p.peekN(1).Type == TokenRParen {
+			break
+		}
 	}
 
 	// Parse result variable
@@ -501,10 +577,13 @@ func (p *Parser) parseAggregation() (Aggregation, error) {
 	}
 
 	return Aggregation{
-		Op:       op,
-		Variable: aggVar,
-		Body:     body,
-		Into:     Variable(resultTok.Value),
+		Op:        op,
+		Variable:  aggVar,
+		Param:     param,
+		Witnesses: witnesses,
+		GroupBy:   groupBy,
+		Body:      body,
+		Into:      Variable(resultTok.Value),
 	}, nil
 }
 
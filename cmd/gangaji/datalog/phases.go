@@ -0,0 +1,89 @@
+package datalog
+
+import "sort"
+
+// buildPhaseCategory is the category of the markers Bazel writes when the
+// command enters a new phase
+const buildPhaseCategory = "build phase marker"
+
+// phaseNames maps Bazel's phase marker descriptions to short phase names
+var phaseNames = map[string]string{
+	"Launch Blaze":                  "launch",
+	"Initialize command":            "init",
+	"Evaluate target patterns":      "target pattern evaluation",
+	"Load and analyze dependencies": "analysis",
+	"Analyze licenses":              "license checking",
+	"Prepare for build":             "prepare",
+	"Build artifacts":               "execution",
+	"Complete build":                "finish",
+}
+
+// BuildPhase is the interval between one phase marker and the next (or the
+// end of the profile) on one pid
+type BuildPhase struct {
+	Name    string  `json:"name"`
+	Marker  string  `json:"marker"` // The marker's own name, e.g. "Build artifacts"
+	Pid     int     `json:"pid"`
+	StartUs float64 `json:"startUs"`
+	DurUs   float64 `json:"durUs"`
+}
+
+// ComputeBuildPhases reconstructs phase intervals from the build phase markers
+// in TraceEvents and InstantEvents, ordered by pid and start time
+func ComputeBuildPhases(profile *Profile) []BuildPhase {
+	markers := make(map[int][]TraceEvent)
+	ends := make(map[int]float64)
+	for _, events := range [][]TraceEvent{profile.TraceEvents, profile.InstantEvents} {
+		for _, e := range events {
+			if end := e.Ts + e.Dur; end > ends[e.Pid] {
+				ends[e.Pid] = end
+			}
+			if e.Cat == buildPhaseCategory {
+				markers[e.Pid] = append(markers[e.Pid], e)
+			}
+		}
+	}
+
+	pids := make([]int, 0, len(markers))
+	for pid := range markers {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	var phases []BuildPhase
+	for _, pid := range pids {
+		list := markers[pid]
+		sort.SliceStable(list, func(a, b int) bool { return list[a].Ts < list[b].Ts })
+
+		for i, m := range list {
+			end := ends[pid]
+			if i+1 < len(list) {
+				end = list[i+1].Ts
+			}
+			name, ok := phaseNames[m.Name]
+			if !ok {
+				name = m.Name
+			}
+			phases = append(phases, BuildPhase{
+				Name:    name,
+				Marker:  m.Name,
+				Pid:     pid,
+				StartUs: m.Ts,
+				DurUs:   end - m.Ts,
+			})
+		}
+	}
+	return phases
+}
+
+// phaseAt returns the phase of pid that was running at ts
+func phaseAt(phases []BuildPhase, pid int, ts float64) (string, bool) {
+	name, found := "", false
+	for _, p := range phases {
+		if p.Pid != pid || p.StartUs > ts {
+			continue
+		}
+		name, found = p.Name, true
+	}
+	return name, found
+}
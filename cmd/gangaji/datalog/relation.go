@@ -0,0 +1,106 @@
+package datalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// relation stores the facts of one predicate as a set of tuples, with hash
+// indexes on argument positions built the first time a lookup binds them
+type relation struct {
+	facts   []Fact
+	tuples  map[string]struct{}         // Keys of every fact, for duplicate checks
+	indexes map[uint64]map[string][]int // Bound positions (bit i = arg i) -> key of those args -> fact indexes
+}
+
+func newRelation() *relation {
+	return &relation{
+		tuples:  make(map[string]struct{}),
+		indexes: make(map[uint64]map[string][]int),
+	}
+}
+
+// add inserts f unless an equal fact exists and reports whether it was new
+func (r *relation) add(f Fact) bool {
+	key := tupleKey(f.Args)
+	if _, ok := r.tuples[key]; ok {
+		return false
+	}
+	r.tuples[key] = struct{}{}
+	r.facts = append(r.facts, f)
+
+	id := len(r.facts) - 1
+	for mask, index := range r.indexes {
+		if k, ok := maskedKey(f.Args, mask); ok {
+			index[k] = append(index[k], id)
+		}
+	}
+	return true
+}
+
+// contains reports whether a fact with equal args exists
+func (r *relation) contains(args []interface{}) bool {
+	_, ok := r.tuples[tupleKey(args)]
+	return ok
+}
+
+// lookup returns the indexes of the facts whose args at the positions in mask
+// equal values (one value per set bit, in position order)
+func (r *relation) lookup(mask uint64, values []interface{}) []int {
+	index, ok := r.indexes[mask]
+	if !ok {
+		index = make(map[string][]int)
+		for id, f := range r.facts {
+			if k, ok := maskedKey(f.Args, mask); ok {
+				index[k] = append(index[k], id)
+			}
+		}
+		r.indexes[mask] = index
+	}
+	return index[tupleKey(values)]
+}
+
+// tupleKey encodes values so that values equal under valuesEqual share a key:
+// numbers of any type by their float64 value, everything else as printed
+func tupleKey(values []interface{}) string {
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(valueKey(v))
+	}
+	return b.String()
+}
+
+func valueKey(v interface{}) string {
+	if num, ok := toFloat64NoErr(v); ok {
+		return strconv.FormatFloat(num, 'g', -1, 64)
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// maskedKey is the tupleKey of the args at the positions in mask; false when
+// args is too short for the mask
+func maskedKey(args []interface{}, mask uint64) (string, bool) {
+	var b strings.Builder
+	first := true
+	for i := 0; mask>>uint(i) != 0; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		if i >= len(args) {
+			return "", false
+		}
+		if !first {
+			b.WriteByte(0)
+		}
+		first = false
+		b.WriteString(valueKey(args[i]))
+	}
+	return b.String(), true
+}
@@ -0,0 +1,68 @@
+package datalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestRelation(rows ...[]interface{}) *relation {
+	r := newRelation()
+	for _, args := range rows {
+		r.add(Fact{Predicate: "t", Args: args})
+	}
+	return r
+}
+
+func TestRelationLookup(t *testing.T) {
+	r := newTestRelation(
+		[]interface{}{1, "a", 10.0},
+		[]interface{}{2, "a", 20.0},
+		[]interface{}{3, "b", 20.0},
+	)
+
+	tests := []struct {
+		mask   uint64
+		values []interface{}
+		want   []int
+	}{
+		{0b010, []interface{}{"a"}, []int{0, 1}},
+		{0b100, []interface{}{int64(20)}, []int{1, 2}}, // Numbers match across types
+		{0b110, []interface{}{"b", 20}, []int{2}},
+		{0b101, []interface{}{1, 20}, nil},
+		{0b000, nil, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		if got := r.lookup(tt.mask, tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup(%03b, %v) = %v, want %v", tt.mask, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestRelationIndexSeesLaterFacts(t *testing.T) {
+	r := newTestRelation([]interface{}{1, "a"})
+	if got := r.lookup(0b10, []interface{}{"a"}); !reflect.DeepEqual(got, []int{0}) {
+		t.Fatalf("lookup before add = %v, want [0]", got)
+	}
+
+	// The index now exists and must be updated by add
+	if !r.add(Fact{Predicate: "t", Args: []interface{}{2, "a"}}) {
+		t.Fatal("add of a new fact reported a duplicate")
+	}
+	if r.add(Fact{Predicate: "t", Args: []interface{}{2.0, "a"}}) {
+		t.Fatal("add of an equal fact reported a new one")
+	}
+	if got := r.lookup(0b10, []interface{}{"a"}); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("lookup after add = %v, want [0 1]", got)
+	}
+	if !r.contains([]interface{}{2, "a"}) {
+		t.Error("contains(2, a) = false after add")
+	}
+}
+
+func TestRelationShortFacts(t *testing.T) {
+	// Facts too short for a mask are left out of its index
+	r := newTestRelation([]interface{}{1}, []interface{}{2, "a"})
+	if got := r.lookup(0b10, []interface{}{"a"}); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("lookup = %v, want [1]", got)
+	}
+}
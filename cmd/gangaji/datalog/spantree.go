@@ -0,0 +1,77 @@
+package datalog
+
+import "sort"
+
+// SpanTree holds span containment for Profile.TraceEvents, indexed by event id
+type SpanTree struct {
+	Parent []int     `json:"parent"` // Enclosing span on the same (pid, tid), -1 for roots
+	Depth  []int     `json:"depth"`  // 0 for roots
+	SelfUs []float64 `json:"selfUs"` // Duration not covered by direct children
+}
+
+// ComputeSpanTree nests every span inside the innermost span on the same
+// (pid, tid) that fully contains it. Spans that only partially overlap an
+// earlier span are not nested in it. Async spans belong to no thread and stay
+// roots without children.
+func ComputeSpanTree(events []TraceEvent) *SpanTree {
+	tree := &SpanTree{
+		Parent: make([]int, len(events)),
+		Depth:  make([]int, len(events)),
+		SelfUs: make([]float64, len(events)),
+	}
+
+	type threadKey struct{ pid, tid int }
+	byThread := make(map[threadKey][]int)
+	for i, e := range events {
+		tree.Parent[i] = -1
+		tree.SelfUs[i] = e.Dur
+		if e.Async {
+			continue
+		}
+		key := threadKey{e.Pid, e.Tid}
+		byThread[key] = append(byThread[key], i)
+	}
+
+	for _, ids := range byThread {
+		// Outer spans first: earlier start, then longer duration
+		sort.Slice(ids, func(a, b int) bool {
+			ea, eb := events[ids[a]], events[ids[b]]
+			if ea.Ts != eb.Ts {
+				return ea.Ts < eb.Ts
+			}
+			return ea.Dur > eb.Dur
+		})
+
+		var stack []int
+		for _, id := range ids {
+			e := events[id]
+			end := e.Ts + e.Dur
+
+			// Pop spans that do not contain this one
+			for len(stack) > 0 {
+				top := events[stack[len(stack)-1]]
+				if top.Ts <= e.Ts && end <= top.Ts+top.Dur {
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				tree.Parent[id] = parent
+				tree.Depth[id] = tree.Depth[parent] + 1
+				tree.SelfUs[parent] -= e.Dur
+			}
+			stack = append(stack, id)
+		}
+	}
+
+	// Overlapping children can push self time below zero
+	for i, self := range tree.SelfUs {
+		if self < 0 {
+			tree.SelfUs[i] = 0
+		}
+	}
+
+	return tree
+}
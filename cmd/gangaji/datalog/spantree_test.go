@@ -0,0 +1,27 @@
+package datalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpanTreeSkipsAsyncSpans(t *testing.T) {
+	events := []TraceEvent{
+		{Name: "outer", Ph: "X", Ts: 0, Dur: 100, Pid: 1, Tid: 1},
+		{Name: "inner", Ph: "X", Ts: 10, Dur: 50, Pid: 1, Tid: 1},
+		// Async span on the same tid that would otherwise nest between the two
+		{Name: "fetch", Ph: "X", Ts: 5, Dur: 80, Pid: 1, Tid: 1, Async: true},
+		{Name: "leaf", Ph: "X", Ts: 20, Dur: 10, Pid: 1, Tid: 1},
+	}
+
+	tree := ComputeSpanTree(events)
+	if want := []int{-1, 0, -1, 1}; !reflect.DeepEqual(tree.Parent, want) {
+		t.Errorf("Parent = %v, want %v", tree.Parent, want)
+	}
+	if want := []int{0, 1, 0, 2}; !reflect.DeepEqual(tree.Depth, want) {
+		t.Errorf("Depth = %v, want %v", tree.Depth, want)
+	}
+	if want := []float64{50, 40, 80, 10}; !reflect.DeepEqual(tree.SelfUs, want) {
+		t.Errorf("SelfUs = %v, want %v", tree.SelfUs, want)
+	}
+}
@@ -0,0 +1,89 @@
+package datalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stratum is a group of rules that is evaluated to fixpoint before any rule
+// that reads its predicates through negation or aggregation
+type Stratum struct {
+	Predicates []string // Head predicates defined by the stratum
+	Rules      []Rule
+	Recursive  bool // Whether the rules read the stratum's own predicates
+}
+
+// Stratify orders rules into strata, one per strongly connected component of
+// the predicate dependency graph, each after every stratum it reads. Programs
+// in which a predicate depends on itself through "not" or an aggregate have no
+// such order and are rejected with an error naming the cycle.
+func Stratify(rules []Rule) ([]Stratum, error) {
+	graph := newDependencyGraph(rules)
+
+	var strata []Stratum
+	for _, component := range graph.components() {
+		inComponent := make(map[string]bool, len(component))
+		for _, pred := range component {
+			inComponent[pred] = true
+		}
+
+		// Negating or aggregating a predicate of the same component would read
+		// it while it is still being derived
+		for _, pred := range component {
+			for _, dep := range graph.edges[pred] {
+				if dep.through != "" && inComponent[dep.predicate] {
+					return nil, fmt.Errorf("rules cannot be stratified: %s reads %s through %s in the cycle %s",
+						pred, dep.predicate, dep.through, graph.describeCycle(pred, dep, inComponent))
+				}
+			}
+		}
+
+		stratum := Stratum{Predicates: component, Recursive: graph.recursive(component)}
+		for _, rule := range rules {
+			if inComponent[rule.Head.Predicate] {
+				stratum.Rules = append(stratum.Rules, rule)
+			}
+		}
+		strata = append(strata, stratum)
+	}
+	return strata, nil
+}
+
+// describeCycle formats the cycle that starts with the edge from pred to dep
+// and returns to pred within the component, e.g. "a -> not b -> c -> a"
+func (g *dependencyGraph) describeCycle(pred string, dep dependency, inComponent map[string]bool) string {
+	// Shortest path from dep back to pred, breadth-first within the component
+	from := map[string]dependency{dep.predicate: {}}
+	queue := []string{dep.predicate}
+	for len(queue) > 0 && pred != dep.predicate {
+		current := queue[0]
+		queue = queue[1:]
+		if current == pred {
+			break
+		}
+		for _, next := range g.edges[current] {
+			if _, seen := from[next.predicate]; seen || !inComponent[next.predicate] {
+				continue
+			}
+			from[next.predicate] = dependency{current, next.through}
+			queue = append(queue, next.predicate)
+		}
+	}
+
+	// Walk back from pred to dep, then prepend the offending edge
+	var steps []string
+	for current := pred; current != dep.predicate; {
+		edge := from[current]
+		steps = append([]string{dependencyLabel(edge.through, current)}, steps...)
+		current = edge.predicate
+	}
+	steps = append([]string{pred, dependencyLabel(dep.through, dep.predicate)}, steps...)
+	return strings.Join(steps, " -> ")
+}
+
+func dependencyLabel(through, pred string) string {
+	if through == "" {
+		return pred
+	}
+	return through + " " + pred
+}
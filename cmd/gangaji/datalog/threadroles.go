@@ -0,0 +1,95 @@
+package datalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultThreadRole is the role of threads no pattern matches
+const defaultThreadRole = "other"
+
+// ThreadRolePattern assigns Role to threads whose name contains Pattern
+// (case-insensitive), from thread_role_pattern facts in the rules
+type ThreadRolePattern struct {
+	Pattern string
+	Role    string
+}
+
+// ThreadRoleOf returns the role of the longest pattern contained in name. On
+// equal length the later pattern wins, so rules loaded after the built-in
+// table can override it.
+func ThreadRoleOf(name string, patterns []ThreadRolePattern) string {
+	name = strings.ToLower(name)
+	role, longest := defaultThreadRole, 0
+	for _, p := range patterns {
+		if p.Pattern == "" || len(p.Pattern) < longest {
+			continue
+		}
+		if strings.Contains(name, strings.ToLower(p.Pattern)) {
+			role, longest = p.Role, len(p.Pattern)
+		}
+	}
+	return role
+}
+
+// generateThreadRoleFacts classifies every thread with spans and sums busy
+// time per role, and self time per role and category
+func generateThreadRoleFacts(profile *Profile, utilization *Utilization, tree *SpanTree) []Fact {
+	type threadKey struct{ pid, tid int }
+
+	var facts []Fact
+	roles := make(map[threadKey]string, len(utilization.Threads))
+	roleBusy := make(map[string]float64)
+	for _, t := range utilization.Threads {
+		role := ThreadRoleOf(t.Name, profile.ThreadRoles)
+		roles[threadKey{t.Pid, t.Tid}] = role
+		roleBusy[role] += t.BusyUs
+
+		// thread_role(pid, tid, role)
+		facts = append(facts, Fact{
+			Predicate: "thread_role",
+			Args:      []interface{}{t.Pid, t.Tid, role},
+		})
+	}
+
+	type roleCategory struct{ role, cat string }
+	categoryTime := make(map[roleCategory]float64)
+	for i, e := range profile.TraceEvents {
+		if role, ok := roles[threadKey{e.Pid, e.Tid}]; ok {
+			categoryTime[roleCategory{role, e.Cat}] += tree.SelfUs[i]
+		}
+	}
+
+	names := make([]string, 0, len(roleBusy))
+	for role := range roleBusy {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		// role_time(role, busy_us)
+		facts = append(facts, Fact{
+			Predicate: "role_time",
+			Args:      []interface{}{role, roleBusy[role]},
+		})
+	}
+
+	keys := make([]roleCategory, 0, len(categoryTime))
+	for key := range categoryTime {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].role != keys[b].role {
+			return keys[a].role < keys[b].role
+		}
+		return keys[a].cat < keys[b].cat
+	})
+	for _, key := range keys {
+		// role_category_time(role, category, self_us)
+		facts = append(facts, Fact{
+			Predicate: "role_category_time",
+			Args:      []interface{}{key.role, key.cat, categoryTime[key]},
+		})
+	}
+
+	return facts
+}
@@ -151,22 +151,39 @@ func (f FunctionCall) String() string {
 	return fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", "))
 }
 
-// Aggregation represents an aggregation (e.g., aggregate(sum(?Dur), ...))
+// Aggregation represents an aggregation (e.g., aggregate(sum(?Dur), ...)).
+// With GroupBy (aggregate(sum(?Dur) by ?Mnemonic, ...)) it yields one binding
+// per distinct value of the group variables instead of a single one.
 type Aggregation struct {
-	Op       AggregateOp
-	Variable Variable   // Variable to aggregate (e.g., ?Dur for sum(?Dur))
-	Body     []Clause   // Clauses to aggregate over
-	Into     Variable   // Result variable
-}
-
+	Op        AggregateOp
+	Variable  Variable   // Variable to aggregate (e.g., ?Dur for sum(?Dur))
+	Param     float64    // Percentile (0-100) for percentile(?Dur, 90)
+	Witnesses []Variable // Variables argmax/argmin bind from the winning result
+	GroupBy   []Variable // Group variables, bound in every result
+	Body      []Clause   // Clauses to aggregate over
+	Into      Variable   // Result variable
+}
+
+// AggregateOp is the operation of an aggregation. percentile takes the
+// percentile as a second argument (nearest rank), stddev is the population
+// standard deviation, count_distinct counts distinct values of any type,
+// argmax/argmin bind their witness variables from the result with the largest
+// or smallest value, and collect builds a []interface{} in body order.
 type AggregateOp string
 
 const (
-	AggCount AggregateOp = "count"
-	AggSum   AggregateOp = "sum"
-	AggMax   AggregateOp = "max"
-	AggMin   AggregateOp = "min"
-	AggAvg   AggregateOp = "avg"
+	AggCount         AggregateOp = "count"
+	AggSum           AggregateOp = "sum"
+	AggMax           AggregateOp = "max"
+	AggMin           AggregateOp = "min"
+	AggAvg           AggregateOp = "avg"
+	AggPercentile    AggregateOp = "percentile"
+	AggMedian        AggregateOp = "median"
+	AggStddev        AggregateOp = "stddev"
+	AggCountDistinct AggregateOp = "count_distinct"
+	AggArgMax        AggregateOp = "argmax"
+	AggArgMin        AggregateOp = "argmin"
+	AggCollect       AggregateOp = "collect"
 )
 
 func (a Aggregation) isClause() {}
@@ -175,7 +192,27 @@ func (a Aggregation) String() string {
 	for i, c := range a.Body {
 		body[i] = c.String()
 	}
-	return fmt.Sprintf("aggregate(%s(%s), %s, %s)", a.Op, a.Variable, strings.Join(body, ", "), a.Into)
+	op := string(a.Op)
+	switch {
+	case a.Op == AggPercentile:
+		op = fmt.Sprintf("%s(%s, %v)", a.Op, a.Variable, a.Param)
+	case len(a.Witnesses) > 0:
+		args := []string{string(a.Variable)}
+		for _, w := range a.Witnesses {
+			args = append(args, string(w))
+		}
+		op = fmt.Sprintf("%s(%s)", a.Op, strings.Join(args, ", "))
+	case a.Op != AggCount:
+		op = fmt.Sprintf("%s(%s)", a.Op, a.Variable)
+	}
+	if len(a.GroupBy) > 0 {
+		groups := make([]string, len(a.GroupBy))
+		for i, v := range a.GroupBy {
+			groups[i] = string(v)
+		}
+		op = fmt.Sprintf("%s by (%s)", op, strings.Join(groups, ", "))
+	}
+	return fmt.Sprintf("aggregate(%s, %s, %s)", op, strings.Join(body, ", "), a.Into)
 }
 
 // Negation represents negation-as-failure (not predicate(...))
@@ -0,0 +1,207 @@
+package datalog
+
+import (
+	"math"
+	"sort"
+)
+
+// minIdleGapUs is the shortest idle stretch reported as an idle_gap; shorter
+// gaps are scheduling noise
+const minIdleGapUs = 100000
+
+// utilizationBuckets is the number of points in each utilization series
+const utilizationBuckets = 200
+
+// criticalPathThreadName is the pseudo-thread Bazel writes its "critical path
+// component" copies of actions to; it is not a worker
+const criticalPathThreadName = "Critical Path"
+
+// Utilization describes how busy each thread was over the build
+type Utilization struct {
+	Threads []ThreadUtilization `json:"threads"`
+	Series  []UtilizationSeries `json:"series"`
+}
+
+// ThreadUtilization is the busy time of one thread within its profile's window
+type ThreadUtilization struct {
+	Pid      int       `json:"pid"`
+	Tid      int       `json:"tid"`
+	Name     string    `json:"name"`
+	BusyUs   float64   `json:"busyUs"`
+	BusyPct  float64   `json:"busyPct"`
+	IdleGaps []IdleGap `json:"idleGaps,omitempty"`
+}
+
+// IdleGap is a stretch of at least minIdleGapUs in which a thread ran nothing
+type IdleGap struct {
+	StartUs float64 `json:"startUs"`
+	DurUs   float64 `json:"durUs"`
+}
+
+// UtilizationSeries is the average number of busy threads of one profile over
+// time, in equal buckets starting at StartUs
+type UtilizationSeries struct {
+	Pid      int       `json:"pid"`
+	StartUs  float64   `json:"startUs"`
+	BucketUs float64   `json:"bucketUs"`
+	Threads  int       `json:"threads"`
+	Busy     []float64 `json:"busy"`
+}
+
+// interval is a half-open [start, end) time range in microseconds
+type interval struct {
+	start, end float64
+}
+
+// ComputeUtilization merges the spans of every (pid, tid) into busy intervals
+// and reports busy time, idle gaps and a busy-threads series per profile.
+// Named threads that ran nothing are reported as idle for the whole window.
+// Async spans and the critical path pseudo-thread do not count as busy time.
+func ComputeUtilization(profile *Profile) *Utilization {
+	type threadKey struct{ pid, tid int }
+
+	events := profile.TraceEvents
+	threadNames := make(map[int]map[int]string, len(profile.Sources))
+	for _, source := range profile.Sources {
+		threadNames[source.Pid] = source.ThreadNames
+	}
+
+	spans := make(map[threadKey][]interval)
+	windows := make(map[int]interval)
+	for _, e := range events {
+		if e.Dur <= 0 || e.Async || e.Cat == criticalPathCategory {
+			continue
+		}
+		key := threadKey{e.Pid, e.Tid}
+		spans[key] = append(spans[key], interval{e.Ts, e.Ts + e.Dur})
+
+		w, ok := windows[e.Pid]
+		if !ok {
+			w = interval{e.Ts, e.Ts + e.Dur}
+		}
+		w.start = math.Min(w.start, e.Ts)
+		w.end = math.Max(w.end, e.Ts+e.Dur)
+		windows[e.Pid] = w
+	}
+
+	// Threads the profile names but that never ran a span are idle slots
+	for pid, names := range threadNames {
+		if _, ok := windows[pid]; !ok {
+			continue
+		}
+		for tid, name := range names {
+			key := threadKey{pid, tid}
+			if _, ok := spans[key]; !ok && name != criticalPathThreadName {
+				spans[key] = nil
+			}
+		}
+	}
+
+	keys := make([]threadKey, 0, len(spans))
+	for key := range spans {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].pid != keys[b].pid {
+			return keys[a].pid < keys[b].pid
+		}
+		return keys[a].tid < keys[b].tid
+	})
+
+	result := &Utilization{}
+	series := make(map[int]*UtilizationSeries)
+	for _, key := range keys {
+		window := windows[key.pid]
+		busy := mergeIntervals(spans[key])
+
+		s, ok := series[key.pid]
+		if !ok {
+			s = &UtilizationSeries{
+				Pid:      key.pid,
+				StartUs:  window.start,
+				BucketUs: (window.end - window.start) / utilizationBuckets,
+				Busy:     make([]float64, utilizationBuckets),
+			}
+			series[key.pid] = s
+		}
+		s.Threads++
+		s.addBusy(busy)
+
+		thread := ThreadUtilization{
+			Pid:  key.pid,
+			Tid:  key.tid,
+			Name: threadNames[key.pid][key.tid],
+		}
+
+		// Walk the busy intervals, recording the gaps between them
+		// (including before the first and after the last)
+		cursor := window.start
+		for _, b := range append(busy, interval{window.end, window.end}) {
+			if gap := b.start - cursor; gap >= minIdleGapUs {
+				thread.IdleGaps = append(thread.IdleGaps, IdleGap{StartUs: cursor, DurUs: gap})
+			}
+			thread.BusyUs += b.end - b.start
+			cursor = b.end
+		}
+		if length := window.end - window.start; length > 0 {
+			thread.BusyPct = thread.BusyUs / length * 100
+		}
+
+		result.Threads = append(result.Threads, thread)
+	}
+
+	for _, key := range keys {
+		if s, ok := series[key.pid]; ok {
+			s.normalize()
+			result.Series = append(result.Series, *s)
+			delete(series, key.pid)
+		}
+	}
+
+	return result
+}
+
+// mergeIntervals returns the union of spans as sorted, non-overlapping intervals
+func mergeIntervals(spans []interval) []interval {
+	sort.Slice(spans, func(a, b int) bool { return spans[a].start < spans[b].start })
+
+	var merged []interval
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			merged[n-1].end = math.Max(merged[n-1].end, s.end)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// addBusy adds the busy time of one thread to the buckets it overlaps
+func (s *UtilizationSeries) addBusy(busy []interval) {
+	if s.BucketUs <= 0 {
+		return
+	}
+	for _, b := range busy {
+		first := int((b.start - s.StartUs) / s.BucketUs)
+		for i := first; i < len(s.Busy); i++ {
+			bucketStart := s.StartUs + float64(i)*s.BucketUs
+			if bucketStart >= b.end {
+				break
+			}
+			overlap := math.Min(b.end, bucketStart+s.BucketUs) - math.Max(b.start, bucketStart)
+			if overlap > 0 {
+				s.Busy[i] += overlap
+			}
+		}
+	}
+}
+
+// normalize turns busy time per bucket into the average number of busy threads
+func (s *UtilizationSeries) normalize() {
+	if s.BucketUs <= 0 {
+		return
+	}
+	for i := range s.Busy {
+		s.Busy[i] /= s.BucketUs
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Spawn is one executed spawn from Bazel's execution log, joined to the trace
+// event of the action that ran it
+type Spawn struct {
+	Event       int    `json:"event"` // Index into TraceEvents, -1 if no span matched
+	Target      string `json:"target"`
+	Mnemonic    string `json:"mnemonic"`
+	Runner      string `json:"runner"`
+	CacheHit    bool   `json:"cacheHit,omitempty"`
+	InputFiles  int64  `json:"inputFiles"`
+	InputBytes  int64  `json:"inputBytes"`
+	OutputBytes int64  `json:"outputBytes"`
+}
+
+// spawnExec is the subset of a SpawnExec (--execution_log_json_file) that
+// gangaji reads
+type spawnExec struct {
+	TargetLabel string `json:"targetLabel"`
+	Mnemonic    string `json:"mnemonic"`
+	Runner      string `json:"runner"`
+	CacheHit    bool   `json:"cacheHit"`
+	Inputs      []struct {
+		Digest struct {
+			SizeBytes protoInt `json:"sizeBytes"`
+		} `json:"digest"`
+	} `json:"inputs"`
+	ActualOutputs []struct {
+		Digest struct {
+			SizeBytes protoInt `json:"sizeBytes"`
+		} `json:"digest"`
+	} `json:"actualOutputs"`
+	Metrics struct {
+		InputBytes protoInt `json:"inputBytes"`
+		InputFiles protoInt `json:"inputFiles"`
+	} `json:"metrics"`
+}
+
+func loadExecutionLog(path string) ([]Spawn, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	// Check if gzipped
+	if strings.HasSuffix(path, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	// Only the JSON log is supported; the binary and compact logs are protobuf
+	buffered := bufio.NewReaderSize(reader, 1<<20)
+	head, _ := buffered.Peek(64)
+	if trimmed := bytes.TrimSpace(head); len(trimmed) > 0 && trimmed[0] != '{' {
+		return nil, fmt.Errorf("not a JSON execution log; write one with --execution_log_json_file")
+	}
+
+	// The log is a stream of (pretty-printed) JSON objects, one per spawn
+	var spawns []Spawn
+	dec := json.NewDecoder(buffered)
+	for {
+		var exec spawnExec
+		if err := dec.Decode(&exec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		spawns = append(spawns, exec.spawn())
+	}
+
+	return spawns, nil
+}
+
+// spawn summarizes a SpawnExec, preferring the metrics Bazel already computed
+func (e *spawnExec) spawn() Spawn {
+	s := Spawn{
+		Event:      -1,
+		Target:     e.TargetLabel,
+		Mnemonic:   e.Mnemonic,
+		Runner:     e.Runner,
+		CacheHit:   e.CacheHit,
+		InputFiles: int64(e.Metrics.InputFiles),
+		InputBytes: int64(e.Metrics.InputBytes),
+	}
+
+	if s.InputFiles == 0 {
+		s.InputFiles = int64(len(e.Inputs))
+	}
+	if s.InputBytes == 0 {
+		for _, input := range e.Inputs {
+			s.InputBytes += int64(input.Digest.SizeBytes)
+		}
+	}
+	for _, output := range e.ActualOutputs {
+		s.OutputBytes += int64(output.Digest.SizeBytes)
+	}
+
+	return s
+}
+
+// joinSpawns points each spawn at the action span of pid with the same target
+// and mnemonic. Several spawns of one (target, mnemonic) are matched to the
+// spans in start order.
+func joinSpawns(events []TraceEvent, spawns []Spawn, pid int) {
+	type actionKey struct{ target, mnemonic string }
+
+	spans := make(map[actionKey][]int)
+	for i, e := range events {
+		if e.Pid != pid {
+			continue
+		}
+		target, _ := e.Args["target"].(string)
+		mnemonic, _ := e.Args["mnemonic"].(string)
+		if target == "" || mnemonic == "" {
+			continue
+		}
+		key := actionKey{target, mnemonic}
+		spans[key] = append(spans[key], i)
+	}
+	for _, ids := range spans {
+		sort.SliceStable(ids, func(a, b int) bool {
+			return events[ids[a]].Ts < events[ids[b]].Ts
+		})
+	}
+
+	for i := range spawns {
+		key := actionKey{spawns[i].Target, spawns[i].Mnemonic}
+		if ids := spans[key]; len(ids) > 0 {
+			spawns[i].Event = ids[0]
+			spans[key] = ids[1:]
+		}
+	}
+}
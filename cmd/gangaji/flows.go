@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FlowEdge links the span a flow starts in to the span it continues in
+type FlowEdge struct {
+	From int    `json:"from"` // Index into ProfileData.TraceEvents
+	To   int    `json:"to"`   // Index into ProfileData.TraceEvents
+	Name string `json:"name,omitempty"`
+	Cat  string `json:"cat,omitempty"`
+}
+
+type threadKey struct {
+	pid int
+	tid int
+}
+
+type flowKey struct {
+	cat string
+	id  string
+}
+
+// threadSpans holds the indices of one thread's spans sorted by start time
+type threadSpans struct {
+	indices []int
+	maxDur  float64
+}
+
+// resolveFlows binds flow events (ph: "s", "t", "f") to trace event spans and
+// returns an edge for every consecutive pair of points in the same flow.
+//
+// A point binds to the innermost span on its thread that encloses its
+// timestamp. Finish events without bp: "e" may also bind to the next span
+// starting on the thread, as in the Chrome trace viewer. Async spans belong
+// to no thread, so points never bind to them.
+func resolveFlows(events []TraceEvent, points []TraceEvent) []FlowEdge {
+	if len(points) == 0 {
+		return nil
+	}
+
+	threads := make(map[threadKey]*threadSpans)
+	for i, e := range events {
+		if e.Async {
+			continue
+		}
+		key := threadKey{e.Pid, e.Tid}
+		spans := threads[key]
+		if spans == nil {
+			spans = &threadSpans{}
+			threads[key] = spans
+		}
+		spans.indices = append(spans.indices, i)
+		if e.Dur > spans.maxDur {
+			spans.maxDur = e.Dur
+		}
+	}
+	for _, spans := range threads {
+		idx := spans.indices
+		sort.Slice(idx, func(i, j int) bool {
+			a, b := events[idx[i]], events[idx[j]]
+			if a.Ts != b.Ts {
+				return a.Ts < b.Ts
+			}
+			return a.Dur > b.Dur
+		})
+	}
+
+	bind := func(p TraceEvent) (int, bool) {
+		spans := threads[threadKey{p.Pid, p.Tid}]
+		if spans == nil {
+			return 0, false
+		}
+		idx := spans.indices
+
+		// First span starting after the point
+		next := sort.Search(len(idx), func(i int) bool {
+			return events[idx[i]].Ts > p.Ts
+		})
+
+		// Innermost enclosing span is the latest-starting one that still covers ts
+		for i := next - 1; i >= 0; i-- {
+			e := events[idx[i]]
+			if e.Ts < p.Ts-spans.maxDur {
+				break
+			}
+			if p.Ts <= e.Ts+e.Dur {
+				return idx[i], true
+			}
+		}
+
+		if p.Ph == "f" && p.Bp != "e" {
+			if next > 0 && events[idx[next-1]].Ts == p.Ts {
+				return idx[next-1], true
+			}
+			if next < len(idx) {
+				return idx[next], true
+			}
+		}
+		return 0, false
+	}
+
+	// Group points by flow, keeping their order
+	var order []flowKey
+	flows := make(map[flowKey][]TraceEvent)
+	for _, p := range points {
+		key := flowKey{cat: p.Cat, id: fmt.Sprint(p.ID)}
+		if _, ok := flows[key]; !ok {
+			order = append(order, key)
+		}
+		flows[key] = append(flows[key], p)
+	}
+
+	type edgeKey struct{ from, to int }
+	seen := make(map[edgeKey]bool)
+	var edges []FlowEdge
+
+	for _, key := range order {
+		flow := flows[key]
+		sort.SliceStable(flow, func(i, j int) bool {
+			return flow[i].Ts < flow[j].Ts
+		})
+
+		prev := -1
+		for _, p := range flow {
+			if p.Ph == "s" {
+				prev = -1
+			}
+
+			idx, ok := bind(p)
+			if !ok {
+				continue
+			}
+
+			if prev >= 0 && prev != idx && !seen[edgeKey{prev, idx}] {
+				seen[edgeKey{prev, idx}] = true
+				edges = append(edges, FlowEdge{From: prev, To: idx, Name: p.Name, Cat: p.Cat})
+			}
+			prev = idx
+
+			if p.Ph == "f" {
+				prev = -1
+			}
+		}
+	}
+
+	return edges
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"compress/gzip"
 	"embed"
 	"encoding/json"
@@ -11,9 +12,10 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/pprof/profile"
 	"github.com/thesayyn/gangaji/cmd/gangaji/datalog"
@@ -25,14 +27,17 @@ var flamegraphHTML embed.FS
 
 // TraceEvent represents a single event in Chrome Trace Event Format
 type TraceEvent struct {
-	Name string                 `json:"name"`
-	Cat  string                 `json:"cat,omitempty"`
-	Ph   string                 `json:"ph"`
-	Ts   float64                `json:"ts"`
-	Dur  float64                `json:"dur,omitempty"`
-	Pid  int                    `json:"pid,omitempty"`
-	Tid  int                    `json:"tid,omitempty"`
-	Args map[string]interface{} `json:"args,omitempty"`
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Ph    string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur,omitempty"`
+	Pid   int                    `json:"pid,omitempty"`
+	Tid   int                    `json:"tid,omitempty"`
+	ID    interface{}            `json:"id,omitempty"`
+	Bp    string                 `json:"bp,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+	Async bool                   `json:"async,omitempty"` // Paired from async (b/e) events, which belong to no thread
 }
 
 // ThreadMetadata holds pre-processed thread information
@@ -45,28 +50,88 @@ type ThreadMetadata struct {
 type CounterEvent struct {
 	Name string                 `json:"name"`
 	Ts   float64                `json:"ts"`
+	Pid  int                    `json:"pid,omitempty"`
 	Args map[string]interface{} `json:"args,omitempty"`
 }
 
+// InvocationInfo is the otherData Bazel writes at the top of a profile
+type InvocationInfo struct {
+	BuildID        string                 `json:"buildId,omitempty"`
+	OutputBase     string                 `json:"outputBase,omitempty"`
+	Date           string                 `json:"date,omitempty"`
+	BazelVersion   string                 `json:"bazelVersion,omitempty"`
+	ProfileStartTs float64                `json:"profileStartTs,omitempty"`
+	Other          map[string]interface{} `json:"other,omitempty"` // Remaining otherData keys
+}
+
+// ProfileSource describes one loaded profile file. Every source gets its own
+// pid so that threads from different invocations never share a lane.
+type ProfileSource struct {
+	Name           string                  `json:"name"`
+	Pid            int                     `json:"pid"`
+	ThreadMetadata map[int]*ThreadMetadata `json:"threadMetadata,omitempty"`
+	MainThreadTid  *int                    `json:"mainThreadTid,omitempty"`
+	SampleType     string                  `json:"sampleType,omitempty"` // pprof sample type shown for this source
+	SampleUnit     string                  `json:"sampleUnit,omitempty"` // Unit of Dur when it is not a duration
+	Invocation     *InvocationInfo         `json:"invocation,omitempty"`
+}
+
 // ProfileData represents the complete profile data structure
 type ProfileData struct {
 	TraceEvents    []TraceEvent               `json:"traceEvents"`
+	InstantEvents  []TraceEvent               `json:"instantEvents,omitempty"`
 	CounterEvents  []CounterEvent             `json:"counterEvents,omitempty"`
-	ThreadMetadata map[int]*ThreadMetadata    `json:"threadMetadata,omitempty"`
+	FlowEdges      []FlowEdge                 `json:"flowEdges,omitempty"`
+	ThreadMetadata map[int]*ThreadMetadata    `json:"threadMetadata,omitempty"` // First source's threads
 	MainThreadTid  *int                       `json:"mainThreadTid,omitempty"`
+	Invocation     *InvocationInfo            `json:"invocation,omitempty"` // First source's invocation
+	Sources        []ProfileSource            `json:"sources,omitempty"`
+	FunctionCosts  []FunctionCost             `json:"-"`
+	BuildEvents    *BuildEvents               `json:"buildEvents,omitempty"`
+	Spawns         []Spawn                    `json:"spawns,omitempty"`
+	CriticalPath   []datalog.CriticalPathStep `json:"criticalPath,omitempty"`
+	BuildPhases    []datalog.BuildPhase       `json:"buildPhases,omitempty"`
+	SpanTree       *datalog.SpanTree          `json:"-"` // Served separately at /api/tree
+	Utilization    *datalog.Utilization       `json:"-"` // Served separately at /api/utilization
+	Distributions  *datalog.Distributions     `json:"-"` // Served separately at /api/distributions
+	Packages       *datalog.PackageTimes      `json:"-"` // Served separately at /api/packages
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeated flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 var (
-	profilePath         string
+	profilePaths        stringList
 	starlarkProfilePath string
+	starlarkLayout      string
+	sampleIndex         string
+	bepPath             string
+	executionLogPath    string
+	concurrencyRes      time.Duration
+	concurrencyActions  bool
 	rulesDir            string
 	port                int
 	openBrowserFlag     bool
 )
 
 func init() {
-	flag.StringVar(&profilePath, "profile", "", "Path to Bazel profile JSON (can be .json or .json.gz)")
+	flag.Var(&profilePaths, "profile", "Path or glob of Bazel profile JSON (can be .json or .json.gz); repeat to load several")
 	flag.StringVar(&starlarkProfilePath, "starlark_cpu_profile", "", "Path to Starlark CPU profile")
+	flag.StringVar(&starlarkLayout, "starlark_layout", "tree", "Starlark call tree layout: tree (callers in sample order) or left-heavy (children sorted by total time)")
+	flag.StringVar(&sampleIndex, "sample_index", "", "pprof sample type to display for the Starlark profile, by name (e.g. alloc_space) or index (default: the profile's default type)")
+	flag.StringVar(&bepPath, "bep", "", "Path to Build Event Protocol JSON (bazel --build_event_json_file) with target and test outcomes")
+	flag.StringVar(&executionLogPath, "execution_log", "", "Path to Bazel execution log (bazel --execution_log_json_file) with per-spawn runner and cache details")
+	flag.DurationVar(&concurrencyRes, "concurrency_resolution", 0, "Bucket width of the concurrency timeline used by rules, e.g. 1s (default: 1/100 of the build)")
+	flag.BoolVar(&concurrencyActions, "concurrency_actionable_only", false, "Count only actionable events (actions with targets or mnemonics) in the concurrency timeline")
 	flag.StringVar(&rulesDir, "rules_dir", "", "Path to directory with custom .dl rule files (optional)")
 	flag.IntVar(&port, "port", 8080, "HTTP server port")
 	flag.BoolVar(&openBrowserFlag, "open", true, "Open browser automatically")
@@ -75,11 +140,11 @@ func init() {
 func main() {
 	flag.Parse()
 
-	if profilePath == "" && starlarkProfilePath == "" {
+	if len(profilePaths) == 0 && starlarkProfilePath == "" {
 		fmt.Println("Gangaji - Bazel Build Profiler")
 		fmt.Println()
 		fmt.Println("Usage:")
-		fmt.Println("  gangaji --profile=<path> [--starlark_cpu_profile=<path>] [flags]")
+		fmt.Println("  gangaji --profile=<path> [--profile=<path>...] [--starlark_cpu_profile=<path>] [flags]")
 		fmt.Println()
 		fmt.Println("Flags:")
 		flag.PrintDefaults()
@@ -93,9 +158,23 @@ func main() {
 		fmt.Println()
 		fmt.Println("  # Both profiles combined")
 		fmt.Println("  gangaji --profile=profile.json --starlark_cpu_profile=starlark.json")
+		fmt.Println()
+		fmt.Println("  # Several profiles side by side (e.g. CI shards)")
+		fmt.Println("  gangaji --profile=shard1.json --profile=shard2.json")
+		fmt.Println("  gangaji --profile='shards/*/profile.json.gz'")
+		fmt.Println()
+		fmt.Println("  # Profile with target and test outcomes")
+		fmt.Println("  gangaji --profile=profile.json --bep=bep.json")
+		fmt.Println()
+		fmt.Println("  # Profile with per-spawn runner and cache details")
+		fmt.Println("  gangaji --profile=profile.json --execution_log=exec.json")
 		os.Exit(1)
 	}
 
+	if starlarkLayout != "tree" && starlarkLayout != "left-heavy" {
+		log.Fatalf("Invalid --starlark_layout %q: must be tree or left-heavy", starlarkLayout)
+	}
+
 	// Load profile data
 	profileData, err := loadProfiles()
 	if err != nil {
@@ -104,16 +183,27 @@ func main() {
 
 	// Print what was loaded
 	var sources []string
-	if profilePath != "" {
-		sources = append(sources, fmt.Sprintf("build profile (%s)", profilePath))
-	}
-	if starlarkProfilePath != "" {
-		sources = append(sources, fmt.Sprintf("starlark profile (%s)", starlarkProfilePath))
+	for _, source := range profileData.Sources {
+		sources = append(sources, source.Name)
 	}
 	fmt.Printf("Loaded %d trace events from %s\n", len(profileData.TraceEvents), strings.Join(sources, " + "))
 
-	// Convert trace events for Datalog evaluation
-	datalogEvents := convertToDatalogEvents(profileData.TraceEvents)
+	// Convert profile for Datalog evaluation
+	datalogProfile := convertToDatalogProfile(profileData)
+	datalogProfile.ConcurrencyBucketUs = float64(concurrencyRes.Microseconds())
+	datalogProfile.ConcurrencyActionableOnly = concurrencyActions
+	profileData.CriticalPath = datalog.ComputeCriticalPathSteps(datalogProfile.TraceEvents)
+	datalogProfile.CriticalPath = profileData.CriticalPath
+	profileData.SpanTree = datalog.ComputeSpanTree(datalogProfile.TraceEvents)
+	datalogProfile.SpanTree = profileData.SpanTree
+	profileData.Utilization = datalog.ComputeUtilization(datalogProfile)
+	datalogProfile.Utilization = profileData.Utilization
+	profileData.Distributions = datalog.ComputeDistributions(datalogProfile.TraceEvents)
+	datalogProfile.Distributions = profileData.Distributions
+	profileData.Packages = datalog.ComputePackageTimes(datalogProfile.TraceEvents)
+	datalogProfile.Packages = profileData.Packages
+	profileData.BuildPhases = datalog.ComputeBuildPhases(datalogProfile)
+	datalogProfile.BuildPhases = profileData.BuildPhases
 
 	// Initialize and run suggestions evaluator
 	evaluator := suggestions.NewEvaluator(rulesDir)
@@ -121,7 +211,7 @@ func main() {
 		log.Printf("Warning: Failed to load rules: %v", err)
 	}
 
-	suggestionsResult, err := evaluator.Evaluate(datalogEvents)
+	suggestionsResult, err := evaluator.Evaluate(datalogProfile)
 	if err != nil {
 		log.Printf("Warning: Failed to evaluate rules: %v", err)
 		suggestionsResult = &suggestions.SuggestionsResult{}
@@ -138,6 +228,10 @@ func main() {
 	http.HandleFunc("/", server.handleIndex)
 	http.HandleFunc("/api/profile", server.handleProfileAPI)
 	http.HandleFunc("/api/suggestions", server.handleSuggestionsAPI)
+	http.HandleFunc("/api/tree", server.handleTreeAPI)
+	http.HandleFunc("/api/utilization", server.handleUtilizationAPI)
+	http.HandleFunc("/api/distributions", server.handleDistributionsAPI)
+	http.HandleFunc("/api/packages", server.handlePackagesAPI)
 
 	addr := fmt.Sprintf(":%d", port)
 	url := fmt.Sprintf("http://localhost:%d", port)
@@ -157,40 +251,128 @@ func main() {
 func loadProfiles() (*ProfileData, error) {
 	result := &ProfileData{
 		TraceEvents:    []TraceEvent{},
+		InstantEvents:  []TraceEvent{},
 		CounterEvents:  []CounterEvent{},
 		ThreadMetadata: make(map[int]*ThreadMetadata),
 	}
 
-	// Load Bazel profile
-	if profilePath != "" {
-		data, err := loadBazelProfile(profilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load Bazel profile: %w", err)
-		}
-		result.TraceEvents = append(result.TraceEvents, data.TraceEvents...)
-		result.CounterEvents = append(result.CounterEvents, data.CounterEvents...)
+	paths, err := expandProfilePaths(profilePaths)
+	if err != nil {
+		return nil, err
+	}
 
-		// Copy thread metadata
-		for tid, meta := range data.ThreadMetadata {
-			result.ThreadMetadata[tid] = meta
-		}
-		if data.MainThreadTid != nil {
-			result.MainThreadTid = data.MainThreadTid
+	// Load Bazel profiles
+	for _, path := range paths {
+		data, err := loadBazelProfile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Bazel profile %s: %w", path, err)
 		}
+		result.addSource(ProfileSource{Name: path}, data)
 	}
 
 	// Load Starlark CPU profile
 	if starlarkProfilePath != "" {
-		events, err := loadStarlarkProfile(starlarkProfilePath)
+		data, source, err := loadStarlarkProfile(starlarkProfilePath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load Starlark profile: %w", err)
 		}
-		result.TraceEvents = append(result.TraceEvents, events...)
+		result.addSource(source, data)
+	}
+
+	// Load Build Event Protocol outcomes
+	if bepPath != "" {
+		events, err := loadBuildEvents(bepPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load build events %s: %w", bepPath, err)
+		}
+		result.BuildEvents = events
+	}
+
+	// Load the execution log and attach each spawn to its action span. The log
+	// records a single invocation, taken to be the first --profile.
+	if executionLogPath != "" {
+		spawns, err := loadExecutionLog(executionLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load execution log %s: %w", executionLogPath, err)
+		}
+		if len(paths) > 0 {
+			joinSpawns(result.TraceEvents, spawns, result.Sources[0].Pid)
+		}
+		result.Spawns = spawns
 	}
 
 	return result, nil
 }
 
+// expandProfilePaths expands glob patterns among the --profile values
+func expandProfilePaths(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no profiles match %q", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// addSource merges a loaded profile into p, moving all of its events into a
+// pid of their own
+func (p *ProfileData) addSource(source ProfileSource, data *ProfileData) {
+	pid := len(p.Sources) + 1
+	source.Pid = pid
+	source.ThreadMetadata = data.ThreadMetadata
+	source.MainThreadTid = data.MainThreadTid
+	source.Invocation = data.Invocation
+	p.Sources = append(p.Sources, source)
+
+	// The first source also provides the top-level thread metadata
+	if len(p.Sources) == 1 {
+		for tid, meta := range data.ThreadMetadata {
+			p.ThreadMetadata[tid] = meta
+		}
+		p.MainThreadTid = data.MainThreadTid
+		p.Invocation = data.Invocation
+	}
+
+	// Flow edges index into TraceEvents, so shift them past earlier events
+	offset := len(p.TraceEvents)
+	for _, edge := range data.FlowEdges {
+		edge.From += offset
+		edge.To += offset
+		p.FlowEdges = append(p.FlowEdges, edge)
+	}
+
+	for i := range data.TraceEvents {
+		data.TraceEvents[i].Pid = pid
+	}
+	for i := range data.InstantEvents {
+		data.InstantEvents[i].Pid = pid
+	}
+	for i := range data.CounterEvents {
+		data.CounterEvents[i].Pid = pid
+	}
+
+	// Avoid copying the (possibly huge) event slice for the first source
+	if len(p.TraceEvents) == 0 {
+		p.TraceEvents = data.TraceEvents
+	} else {
+		p.TraceEvents = append(p.TraceEvents, data.TraceEvents...)
+	}
+	p.InstantEvents = append(p.InstantEvents, data.InstantEvents...)
+	p.CounterEvents = append(p.CounterEvents, data.CounterEvents...)
+	p.FunctionCosts = append(p.FunctionCosts, data.FunctionCosts...)
+}
+
 func loadBazelProfile(path string) (*ProfileData, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -210,74 +392,206 @@ func loadBazelProfile(path string) (*ProfileData, error) {
 		reader = gzReader
 	}
 
-	// Read all data
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read profile: %w", err)
+	profile := &ProfileData{
+		TraceEvents:    []TraceEvent{},
+		InstantEvents:  []TraceEvent{},
+		CounterEvents:  []CounterEvent{},
+		ThreadMetadata: make(map[int]*ThreadMetadata),
 	}
 
-	// Parse JSON
-	var profile ProfileData
-	if err := json.Unmarshal(data, &profile); err != nil {
+	spans := newSpanBuilder()
+	var flowPoints []TraceEvent
+	var lastTs float64
+
+	// Stream events one at a time so memory tracks the events we keep,
+	// not the size of the (possibly multi-gigabyte) JSON document
+	otherData, err := streamTraceEvents(bufio.NewReaderSize(reader, 1<<20), func(event *TraceEvent) {
+		if end := event.Ts + event.Dur; end > lastTs {
+			lastTs = end
+		}
+
+		switch event.Ph {
+		case "M":
+			profile.addMetadata(event)
+		case "X":
+			// Complete events go to TraceEvents
+			if event.Dur > 0 {
+				profile.TraceEvents = append(profile.TraceEvents, *event)
+			}
+		case "B", "b":
+			spans.begin(event)
+		case "E", "e":
+			// Paired begin/end and async events become complete events
+			if span, ok := spans.end(event); ok && span.Dur > 0 {
+				profile.TraceEvents = append(profile.TraceEvents, span)
+			}
+		case "s", "t", "f":
+			// Flow events are bound to spans once every span is known
+			flowPoints = append(flowPoints, *event)
+		case "i", "I", "n":
+			// Instant events are kept as zero-width markers
+			profile.InstantEvents = append(profile.InstantEvents, *event)
+		case "C":
+			// Counter events go to CounterEvents
+			profile.CounterEvents = append(profile.CounterEvents, CounterEvent{
+				Name: event.Name,
+				Ts:   event.Ts,
+				Args: event.Args,
+			})
+		}
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse profile JSON: %w", err)
 	}
 
-	// Extract thread metadata from metadata events
-	profile.ThreadMetadata = make(map[int]*ThreadMetadata)
+	// Spans still open when the profile ends run until the last timestamp
+	for _, span := range spans.unclosed(lastTs) {
+		if span.Dur > 0 {
+			profile.TraceEvents = append(profile.TraceEvents, span)
+		}
+	}
+
+	profile.FlowEdges = resolveFlows(profile.TraceEvents, flowPoints)
 
-	for _, event := range profile.TraceEvents {
-		// Process thread_name metadata events
-		if event.Name == "thread_name" && event.Ph == "M" {
-			tid := event.Tid
-			if profile.ThreadMetadata[tid] == nil {
-				profile.ThreadMetadata[tid] = &ThreadMetadata{}
-			}
-			if name, ok := event.Args["name"].(string); ok {
-				profile.ThreadMetadata[tid].Name = name
-				// Detect main thread
-				if name == "Main Thread" {
-					profile.MainThreadTid = &tid
-				}
+	if otherData != nil {
+		profile.Invocation = parseInvocationInfo(otherData)
+	}
+
+	return profile, nil
+}
+
+// parseInvocationInfo picks the known keys out of a profile's otherData
+func parseInvocationInfo(otherData map[string]interface{}) *InvocationInfo {
+	info := &InvocationInfo{Other: make(map[string]interface{})}
+	for key, value := range otherData {
+		str, _ := value.(string)
+		switch key {
+		case "build_id":
+			info.BuildID = str
+		case "output_base":
+			info.OutputBase = str
+		case "date":
+			info.Date = str
+		case "bazel_version":
+			info.BazelVersion = str
+		case "profile_start_ts":
+			info.ProfileStartTs, _ = value.(float64)
+		default:
+			info.Other[key] = value
+		}
+	}
+	if len(info.Other) == 0 {
+		info.Other = nil
+	}
+	return info
+}
+
+// addMetadata records thread information from a metadata (ph: "M") event
+func (p *ProfileData) addMetadata(event *TraceEvent) {
+	switch event.Name {
+	case "thread_name":
+		tid := event.Tid
+		if p.ThreadMetadata[tid] == nil {
+			p.ThreadMetadata[tid] = &ThreadMetadata{}
+		}
+		if name, ok := event.Args["name"].(string); ok {
+			p.ThreadMetadata[tid].Name = name
+			// Detect main thread
+			if name == "Main Thread" {
+				p.MainThreadTid = &tid
 			}
 		}
 
-		// Process thread_sort_index metadata events
-		if event.Name == "thread_sort_index" && event.Ph == "M" {
-			tid := event.Tid
-			if profile.ThreadMetadata[tid] == nil {
-				profile.ThreadMetadata[tid] = &ThreadMetadata{}
+	case "thread_sort_index":
+		tid := event.Tid
+		if p.ThreadMetadata[tid] == nil {
+			p.ThreadMetadata[tid] = &ThreadMetadata{}
+		}
+		if sortIndex, ok := event.Args["sort_index"].(float64); ok {
+			idx := int(sortIndex)
+			p.ThreadMetadata[tid].SortIndex = &idx
+		}
+	}
+}
+
+// streamTraceEvents decodes a Chrome trace document token by token and calls fn
+// for every element of traceEvents. Both the object form ({"traceEvents": [...]})
+// and the bare array form ([...]) are accepted. The otherData object is
+// returned if present; other top-level keys are skipped.
+func streamTraceEvents(r io.Reader, fn func(event *TraceEvent)) (map[string]interface{}, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		return nil, decodeTraceEventArray(dec, fn)
+	case json.Delim('{'):
+	default:
+		return nil, fmt.Errorf("expected JSON object or array, got %v", tok)
+	}
+
+	var otherData map[string]interface{}
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := keyTok.(string)
+
+		switch key {
+		case "traceEvents":
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
 			}
-			if sortIndex, ok := event.Args["sort_index"].(float64); ok {
-				idx := int(sortIndex)
-				profile.ThreadMetadata[tid].SortIndex = &idx
+			if tok != json.Delim('[') {
+				return nil, fmt.Errorf("expected traceEvents to be an array, got %v", tok)
+			}
+			if err := decodeTraceEventArray(dec, fn); err != nil {
+				return nil, err
+			}
+		case "otherData":
+			if err := dec.Decode(&otherData); err != nil {
+				return nil, err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	// Filter events: complete events (ph: "X") go to TraceEvents, counter events (ph: "C") go to CounterEvents
-	filtered := make([]TraceEvent, 0, len(profile.TraceEvents))
-	counterEvents := make([]CounterEvent, 0)
-	for _, event := range profile.TraceEvents {
-		if event.Ph == "X" && event.Dur > 0 {
-			filtered = append(filtered, event)
-		} else if event.Ph == "C" {
-			counterEvents = append(counterEvents, CounterEvent{
-				Name: event.Name,
-				Ts:   event.Ts,
-				Args: event.Args,
-			})
+	// Consume closing '}'
+	_, err = dec.Token()
+	return otherData, err
+}
+
+// decodeTraceEventArray decodes array elements after the opening '[' has been
+// consumed, up to and including the closing ']'
+func decodeTraceEventArray(dec *json.Decoder, fn func(event *TraceEvent)) error {
+	for dec.More() {
+		var event TraceEvent
+		if err := dec.Decode(&event); err != nil {
+			return err
 		}
+		fn(&event)
 	}
-	profile.TraceEvents = filtered
-	profile.CounterEvents = counterEvents
 
-	return &profile, nil
+	_, err := dec.Token()
+	return err
 }
 
-func loadStarlarkProfile(path string) ([]TraceEvent, error) {
+func loadStarlarkProfile(path string) (*ProfileData, ProfileSource, error) {
+	source := ProfileSource{Name: path}
+
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, source, err
 	}
 	defer file.Close()
 
@@ -286,121 +600,95 @@ func loadStarlarkProfile(path string) ([]TraceEvent, error) {
 	if err != nil {
 		// Try JSON trace format as fallback
 		file.Seek(0, 0)
-		return loadStarlarkProfileJSON(file)
+		events, err := loadStarlarkProfileJSON(file)
+		if err != nil {
+			return nil, source, err
+		}
+		return &ProfileData{TraceEvents: events}, source, nil
 	}
 
-	return convertPprofToTraceEvents(prof), nil
-}
-
-func loadStarlarkProfileJSON(reader io.Reader) ([]TraceEvent, error) {
-	data, err := io.ReadAll(reader)
+	index, err := prof.SampleIndexByName(sampleIndex)
 	if err != nil {
-		return nil, err
+		return nil, source, err
+	}
+	if index < 0 || index >= len(prof.SampleType) {
+		return nil, source, fmt.Errorf("profile has no sample types")
 	}
 
-	var profileData ProfileData
-	if err := json.Unmarshal(data, &profileData); err != nil {
-		log.Printf("Warning: Starlark profile format not recognized, skipping")
-		return []TraceEvent{}, nil
+	// Durations are converted to microseconds; anything else keeps its unit
+	sampleType := prof.SampleType[index]
+	source.SampleType = sampleType.Type
+	if _, ok := timeUnitToUs(sampleType.Unit); !ok {
+		source.SampleUnit = sampleType.Unit
 	}
 
+	return &ProfileData{
+		TraceEvents:   convertPprofToTraceEvents(prof, index),
+		FunctionCosts: starlarkFunctionCosts(prof),
+	}, source, nil
+}
+
+func loadStarlarkProfileJSON(reader io.Reader) ([]TraceEvent, error) {
 	events := make([]TraceEvent, 0)
-	for _, event := range profileData.TraceEvents {
+	_, err := streamTraceEvents(bufio.NewReader(reader), func(event *TraceEvent) {
 		if event.Ph == "X" && event.Dur > 0 {
 			event.Cat = "starlark"
-			events = append(events, event)
+			events = append(events, *event)
 		}
+	})
+	if err != nil {
+		log.Printf("Warning: Starlark profile format not recognized, skipping")
+		return []TraceEvent{}, nil
 	}
 	return events, nil
 }
 
-func convertPprofToTraceEvents(prof *profile.Profile) []TraceEvent {
+func convertPprofToTraceEvents(prof *profile.Profile, sampleIndex int) []TraceEvent {
 	events := make([]TraceEvent, 0)
 
-	// Get the time unit (usually nanoseconds or microseconds)
-	timeUnit := float64(1) // default to 1 (nanoseconds)
-	for _, st := range prof.SampleType {
-		if st.Type == "cpu" || st.Type == "samples" {
-			if st.Unit == "microseconds" {
-				timeUnit = 1000 // convert to nanoseconds for consistency
-			} else if st.Unit == "nanoseconds" {
-				timeUnit = 1
-			} else if st.Unit == "milliseconds" {
-				timeUnit = 1000000
-			}
-		}
+	// Time values become microseconds (what the flamegraph expects); other
+	// units (bytes, counts) are laid out as-is
+	scale, ok := timeUnitToUs(prof.SampleType[sampleIndex].Unit)
+	if !ok {
+		scale = 1
 	}
-
-	// Aggregate samples by function to create synthetic trace events
-	functionTimes := make(map[string]int64)
-	functionFiles := make(map[string]string)
-
-	for _, sample := range prof.Sample {
-		if len(sample.Location) == 0 {
-			continue
-		}
-
-		// Get the value (CPU time)
-		value := int64(0)
-		if len(sample.Value) > 0 {
-			value = sample.Value[0]
-		}
-
-		// Walk the call stack
-		for _, loc := range sample.Location {
-			for _, line := range loc.Line {
-				if line.Function != nil {
-					funcName := line.Function.Name
-					functionTimes[funcName] += value
-					if line.Function.Filename != "" {
-						functionFiles[funcName] = line.Function.Filename
-					}
-				}
-			}
-		}
+	toUs := func(value int64) float64 {
+		return float64(value) * scale
 	}
 
-	// Sort functions by time (descending)
-	type funcTime struct {
-		name string
-		time int64
+	// Build the call tree so nested events keep caller/callee structure
+	tree := buildCallTree(prof, sampleIndex)
+	if starlarkLayout == "left-heavy" {
+		tree.sortLeftHeavy()
 	}
-	var sorted []funcTime
-	for name, time := range functionTimes {
-		sorted = append(sorted, funcTime{name, time})
-	}
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].time > sorted[j].time
-	})
-
-	// Create trace events with synthetic timestamps
-	// Lay them out sequentially for visualization
-	currentTs := float64(0)
-	for _, ft := range sorted {
-		if ft.time <= 0 {
-			continue
-		}
 
-		// Duration in microseconds (what the flamegraph expects)
-		durUs := float64(ft.time) * timeUnit / 1000
+	// Lay the roots out one after another; children nest inside their callers
+	var offset int64
+	for _, root := range tree.children {
+		root.layout(offset, toUs, func(node *callNode, ts, dur float64) {
+			if dur <= 0 {
+				return
+			}
 
-		event := TraceEvent{
-			Name: ft.name,
-			Cat:  "starlark",
-			Ph:   "X",
-			Ts:   currentTs,
-			Dur:  durUs,
-			Args: map[string]interface{}{
-				"mnemonic": "Starlark",
-			},
-		}
+			event := TraceEvent{
+				Name: node.name,
+				Cat:  "starlark",
+				Ph:   "X",
+				Ts:   ts,
+				Dur:  dur,
+				Args: map[string]interface{}{
+					"mnemonic": "Starlark",
+					"self_us":  toUs(node.self),
+				},
+			}
 
-		if file, ok := functionFiles[ft.name]; ok {
-			event.Args["file"] = file
-		}
+			if node.file != "" {
+				event.Args["file"] = node.file
+			}
 
-		events = append(events, event)
-		currentTs += durUs
+			events = append(events, event)
+		})
+		offset += root.total
 	}
 
 	return events
@@ -442,6 +730,34 @@ func (s *Server) handleSuggestionsAPI(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(s.suggestionsResult)
 }
 
+// handleTreeAPI serves span nesting (parent, depth, self time) by trace event index
+func (s *Server) handleTreeAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.profileData.SpanTree)
+}
+
+// handleUtilizationAPI serves per-thread busy time and busy threads over time
+func (s *Server) handleUtilizationAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.profileData.Utilization)
+}
+
+// handleDistributionsAPI serves duration percentiles per mnemonic, package and category
+func (s *Server) handleDistributionsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.profileData.Distributions)
+}
+
+// handlePackagesAPI serves target time rolled up by package and repository
+func (s *Server) handlePackagesAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.profileData.Packages)
+}
+
 func generateHTML(profileJSON string) string {
 	// Read embedded flamegraph HTML
 	htmlBytes, err := flamegraphHTML.ReadFile("flamegraph.html")
@@ -497,19 +813,188 @@ func openBrowser(url string) {
 	}
 }
 
+// convertToDatalogProfile converts main.ProfileData to datalog.Profile
+func convertToDatalogProfile(data *ProfileData) *datalog.Profile {
+	events, flowEdges, spawns := timedEvents(data)
+	return &datalog.Profile{
+		TraceEvents:   convertToDatalogEvents(events),
+		InstantEvents: convertToDatalogEvents(data.InstantEvents),
+		CounterEvents: convertToDatalogCounters(data.CounterEvents),
+		FlowEdges:     convertToDatalogFlowEdges(flowEdges),
+		Sources:       convertToDatalogSources(data.Sources),
+		FunctionCosts: convertToDatalogFunctionCosts(data.FunctionCosts),
+		BuildEvents:   convertToDatalogBuildEvents(data.BuildEvents),
+		Spawns:        convertToDatalogSpawns(spawns),
+	}
+}
+
+// timedEvents leaves out the spans of sources whose Dur is not a duration
+// (e.g. a Starlark profile shown by alloc_space), since every fact reads Dur
+// as microseconds; their cost is still in starlark_function_cost. Flow edges
+// and spawns are renumbered to the remaining events.
+func timedEvents(data *ProfileData) ([]TraceEvent, []FlowEdge, []Spawn) {
+	untimed := make(map[int]bool)
+	for _, source := range data.Sources {
+		if source.SampleUnit != "" {
+			untimed[source.Pid] = true
+		}
+	}
+	if len(untimed) == 0 {
+		return data.TraceEvents, data.FlowEdges, data.Spawns
+	}
+
+	index := make([]int, len(data.TraceEvents))
+	var events []TraceEvent
+	for i, e := range data.TraceEvents {
+		index[i] = -1
+		if !untimed[e.Pid] {
+			index[i] = len(events)
+			events = append(events, e)
+		}
+	}
+
+	var flowEdges []FlowEdge
+	for _, edge := range data.FlowEdges {
+		if index[edge.From] >= 0 && index[edge.To] >= 0 {
+			edge.From, edge.To = index[edge.From], index[edge.To]
+			flowEdges = append(flowEdges, edge)
+		}
+	}
+
+	spawns := make([]Spawn, len(data.Spawns))
+	for i, s := range data.Spawns {
+		if s.Event >= 0 {
+			s.Event = index[s.Event]
+		}
+		spawns[i] = s
+	}
+	return events, flowEdges, spawns
+}
+
+// convertToDatalogSpawns converts main.Spawn to datalog.Spawn
+func convertToDatalogSpawns(spawns []Spawn) []datalog.Spawn {
+	result := make([]datalog.Spawn, len(spawns))
+	for i, s := range spawns {
+		result[i] = datalog.Spawn{
+			Event:       s.Event,
+			Runner:      s.Runner,
+			CacheHit:    s.CacheHit,
+			InputFiles:  s.InputFiles,
+			InputBytes:  s.InputBytes,
+			OutputBytes: s.OutputBytes,
+		}
+	}
+	return result
+}
+
+// convertToDatalogBuildEvents converts main.BuildEvents to datalog.BuildEvents
+func convertToDatalogBuildEvents(b *BuildEvents) *datalog.BuildEvents {
+	if b == nil {
+		return nil
+	}
+
+	result := &datalog.BuildEvents{
+		Command:           b.Command,
+		CommandLine:       b.CommandLine,
+		ActionCacheHits:   b.ActionCacheHits,
+		ActionCacheMisses: b.ActionCacheMisses,
+	}
+	for _, o := range b.Options {
+		result.Options = append(result.Options, datalog.BuildOption{Name: o.Name, Value: o.Value})
+	}
+	for _, t := range b.Targets {
+		result.Targets = append(result.Targets, datalog.TargetOutcome{Target: t.Target, Status: t.Status})
+	}
+	for _, t := range b.TestSummaries {
+		result.TestSummaries = append(result.TestSummaries, datalog.TargetOutcome{Target: t.Target, Status: t.Status})
+	}
+	for _, t := range b.Tests {
+		result.Tests = append(result.Tests, datalog.TestResult{
+			Target:         t.Target,
+			Run:            t.Run,
+			Shard:          t.Shard,
+			Attempt:        t.Attempt,
+			Status:         t.Status,
+			DurUs:          t.DurUs,
+			CachedLocally:  t.CachedLocally,
+			CachedRemotely: t.CachedRemotely,
+		})
+	}
+	return result
+}
+
+// convertToDatalogFunctionCosts converts main.FunctionCost to datalog.FunctionCost
+func convertToDatalogFunctionCosts(costs []FunctionCost) []datalog.FunctionCost {
+	result := make([]datalog.FunctionCost, len(costs))
+	for i, c := range costs {
+		result[i] = datalog.FunctionCost{
+			Function:   c.Function,
+			File:       c.File,
+			SampleType: c.SampleType,
+			Unit:       c.Unit,
+			Value:      c.Value,
+		}
+	}
+	return result
+}
+
+// convertToDatalogCounters converts main.CounterEvent to datalog.CounterEvent
+func convertToDatalogCounters(counters []CounterEvent) []datalog.CounterEvent {
+	result := make([]datalog.CounterEvent, len(counters))
+	for i, c := range counters {
+		result[i] = datalog.CounterEvent{
+			Name: c.Name,
+			Ts:   c.Ts,
+			Pid:  c.Pid,
+			Args: c.Args,
+		}
+	}
+	return result
+}
+
+// convertToDatalogSources converts main.ProfileSource to datalog.ProfileSource
+func convertToDatalogSources(sources []ProfileSource) []datalog.ProfileSource {
+	result := make([]datalog.ProfileSource, len(sources))
+	for i, s := range sources {
+		result[i] = datalog.ProfileSource{Name: s.Name, Pid: s.Pid, ThreadNames: make(map[int]string)}
+		for tid, meta := range s.ThreadMetadata {
+			result[i].ThreadNames[tid] = meta.Name
+		}
+		if s.Invocation != nil {
+			result[i].Invocation = &datalog.InvocationInfo{
+				BuildID:      s.Invocation.BuildID,
+				OutputBase:   s.Invocation.OutputBase,
+				Date:         s.Invocation.Date,
+				BazelVersion: s.Invocation.BazelVersion,
+			}
+		}
+	}
+	return result
+}
+
+// convertToDatalogFlowEdges converts main.FlowEdge to datalog.FlowEdge
+func convertToDatalogFlowEdges(edges []FlowEdge) []datalog.FlowEdge {
+	result := make([]datalog.FlowEdge, len(edges))
+	for i, e := range edges {
+		result[i] = datalog.FlowEdge{From: e.From, To: e.To}
+	}
+	return result
+}
+
 // convertToDatalogEvents converts main.TraceEvent to datalog.TraceEvent
 func convertToDatalogEvents(events []TraceEvent) []datalog.TraceEvent {
 	result := make([]datalog.TraceEvent, len(events))
 	for i, e := range events {
 		result[i] = datalog.TraceEvent{
-			Name: e.Name,
-			Cat:  e.Cat,
-			Ph:   e.Ph,
-			Ts:   e.Ts,
-			Dur:  e.Dur,
-			Pid:  e.Pid,
-			Tid:  e.Tid,
-			Args: e.Args,
+			Name:  e.Name,
+			Cat:   e.Cat,
+			Ph:    e.Ph,
+			Ts:    e.Ts,
+			Dur:   e.Dur,
+			Pid:   e.Pid,
+			Tid:   e.Tid,
+			Args:  e.Args,
+			Async: e.Async,
 		}
 	}
 	return result
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// spanKey identifies a stack of open begin events. Duration events (B/E) nest
+// per thread, async events (b/e) nest per category and id within a process.
+type spanKey struct {
+	pid   int
+	tid   int
+	async bool
+	cat   string
+	id    string
+}
+
+// spanBuilder pairs begin/end (ph: "B"/"E") and async (ph: "b"/"e") events
+// into complete (ph: "X") spans
+type spanBuilder struct {
+	open map[spanKey][]TraceEvent
+}
+
+func newSpanBuilder() *spanBuilder {
+	return &spanBuilder{
+		open: make(map[spanKey][]TraceEvent),
+	}
+}
+
+func keyForEvent(event *TraceEvent) spanKey {
+	switch event.Ph {
+	case "b", "e":
+		return spanKey{pid: event.Pid, async: true, cat: event.Cat, id: fmt.Sprint(event.ID)}
+	default:
+		return spanKey{pid: event.Pid, tid: event.Tid}
+	}
+}
+
+// begin records an opening event
+func (b *spanBuilder) begin(event *TraceEvent) {
+	key := keyForEvent(event)
+	b.open[key] = append(b.open[key], *event)
+}
+
+// end closes the innermost open event with the same key and returns the
+// resulting complete span. Unmatched end events are ignored.
+func (b *spanBuilder) end(event *TraceEvent) (TraceEvent, bool) {
+	key := keyForEvent(event)
+	stack := b.open[key]
+	if len(stack) == 0 {
+		return TraceEvent{}, false
+	}
+
+	begin := stack[len(stack)-1]
+	if len(stack) == 1 {
+		delete(b.open, key)
+	} else {
+		b.open[key] = stack[:len(stack)-1]
+	}
+
+	return completeSpan(begin, event.Ts, event.Args), true
+}
+
+// unclosed closes every span still open at the end of the profile at endTs,
+// ordered by start time
+func (b *spanBuilder) unclosed(endTs float64) []TraceEvent {
+	var spans []TraceEvent
+	for _, stack := range b.open {
+		for _, begin := range stack {
+			spans = append(spans, completeSpan(begin, endTs, nil))
+		}
+	}
+	b.open = make(map[spanKey][]TraceEvent)
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Ts < spans[j].Ts
+	})
+	return spans
+}
+
+// completeSpan turns a begin event into a complete event ending at endTs.
+// Args from the end event are merged over the begin event's args.
+func completeSpan(begin TraceEvent, endTs float64, endArgs map[string]interface{}) TraceEvent {
+	span := begin
+	span.Ph = "X"
+	span.Dur = endTs - begin.Ts
+	span.Async = begin.Ph == "b"
+
+	if len(endArgs) > 0 {
+		args := make(map[string]interface{}, len(begin.Args)+len(endArgs))
+		for k, v := range begin.Args {
+			args[k] = v
+		}
+		for k, v := range endArgs {
+			args[k] = v
+		}
+		span.Args = args
+	}
+
+	return span
+}
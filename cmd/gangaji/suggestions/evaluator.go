@@ -2,11 +2,14 @@ package suggestions
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,24 +46,32 @@ func NewEvaluator(rulesDir string) *Evaluator {
 	}
 }
 
-// LoadRules loads all rules from embedded and external sources
+// LoadRules loads all rules from embedded and external sources. External
+// files that cannot be loaded are skipped and reported in the returned error;
+// the built-in rules and every other file are still loaded.
 func (e *Evaluator) LoadRules() error {
 	// Load embedded rules
 	if err := e.loadEmbeddedRules(); err != nil {
 		return fmt.Errorf("failed to load embedded rules: %w", err)
 	}
 
+	// Reject derived rules that negate or aggregate their own results
+	if _, err := datalog.Stratify(e.program.Rules); err != nil {
+		return fmt.Errorf("failed to load embedded rules: %w", err)
+	}
+
 	// Load external rules if specified
+	var externalErr error
 	if e.rulesDir != "" {
 		if err := e.loadExternalRules(); err != nil {
-			return fmt.Errorf("failed to load external rules: %w", err)
+			externalErr = fmt.Errorf("failed to load external rules: %w", err)
 		}
 	}
 
 	// Load derived rules into engine
 	e.engine.LoadProgram(e.program)
 
-	return nil
+	return externalErr
 }
 
 // loadEmbeddedRules loads rules from embedded filesystem
@@ -91,9 +102,12 @@ func (e *Evaluator) loadEmbeddedRules() error {
 	})
 }
 
-// loadExternalRules loads rules from external directory
+// loadExternalRules loads rules from external directory. A file that cannot
+// be read or parsed, or whose rules cannot be stratified together with the
+// rules loaded so far, is skipped; the errors of all skipped files are joined.
 func (e *Evaluator) loadExternalRules() error {
-	return filepath.Walk(e.rulesDir, func(path string, info fs.FileInfo, err error) error {
+	var skipped []error
+	err := filepath.Walk(e.rulesDir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -102,19 +116,42 @@ func (e *Evaluator) loadExternalRules() error {
 			return nil
 		}
 
-		// Read and parse external rule file
-		// Note: In a real implementation, you'd use os.ReadFile here
-		// For now, external rules would need to be loaded differently
+		content, err := os.ReadFile(path)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("failed to read %s: %w", path, err))
+			return nil
+		}
+
+		program, err := datalog.Parse(string(content))
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("failed to parse %s: %w", path, err))
+			return nil
+		}
+
+		rules := append(e.program.Rules[:len(e.program.Rules):len(e.program.Rules)], program.Rules...)
+		if _, err := datalog.Stratify(rules); err != nil {
+			skipped = append(skipped, fmt.Errorf("skipping %s: %w", path, err))
+			return nil
+		}
+
+		e.program.Rules = rules
+		e.program.SuggestionRules = append(e.program.SuggestionRules, program.SuggestionRules...)
+
 		return nil
 	})
+	return errors.Join(append(skipped, err)...)
 }
 
-// Evaluate evaluates all rules against the provided trace events
-func (e *Evaluator) Evaluate(events []datalog.TraceEvent) (*SuggestionsResult, error) {
+// Evaluate evaluates all rules against the provided profile
+func (e *Evaluator) Evaluate(profile *datalog.Profile) (*SuggestionsResult, error) {
 	startTime := time.Now()
+	events := profile.TraceEvents
 
-	// Generate facts from trace events
-	facts := datalog.GenerateFacts(events)
+	// Generate facts from the profile, classifying threads with the loaded table
+	if profile.ThreadRoles == nil {
+		profile.ThreadRoles = e.threadRolePatterns()
+	}
+	facts := datalog.GenerateFacts(profile)
 	e.engine.AddFacts(facts)
 
 	// Add event percentage facts
@@ -164,6 +201,28 @@ func (e *Evaluator) Evaluate(events []datalog.TraceEvent) (*SuggestionsResult, e
 	}, nil
 }
 
+// threadRolePatterns collects the thread_role_pattern(Pattern, Role) facts of
+// the loaded rules in load order, so external rules can extend the built-in table
+func (e *Evaluator) threadRolePatterns() []datalog.ThreadRolePattern {
+	var patterns []datalog.ThreadRolePattern
+	for _, rule := range e.program.Rules {
+		if rule.Head.Predicate != "thread_role_pattern" || len(rule.Body) != 0 || len(rule.Head.Args) != 2 {
+			continue
+		}
+		pattern, ok1 := rule.Head.Args[0].(datalog.Constant)
+		role, ok2 := rule.Head.Args[1].(datalog.Constant)
+		if !ok1 || !ok2 {
+			continue
+		}
+		patternStr, ok1 := pattern.Value.(string)
+		roleStr, ok2 := role.Value.(string)
+		if ok1 && ok2 {
+			patterns = append(patterns, datalog.ThreadRolePattern{Pattern: patternStr, Role: roleStr})
+		}
+	}
+	return patterns
+}
+
 // generateSuggestion generates a suggestion from a rule and bindings
 func (e *Evaluator) generateSuggestion(rule datalog.SuggestionRule, bindings datalog.Bindings) datalog.Suggestion {
 	suggestion := datalog.Suggestion{
@@ -180,7 +239,7 @@ func (e *Evaluator) generateSuggestion(rule datalog.SuggestionRule, bindings dat
 	for _, m := range rule.Suggestion.Metrics {
 		metric := datalog.Metric{
 			Label: renderTemplate(m.Label, bindings),
-			Value: renderMetricValue(m.Value, bindings),
+			Value: e.renderMetricValue(m.Value, bindings),
 		}
 		suggestion.Metrics = append(suggestion.Metrics, metric)
 	}
@@ -218,18 +277,15 @@ func renderTemplate(template string, bindings datalog.Bindings) string {
 	return result
 }
 
-// renderMetricValue renders a metric value, potentially calling functions
-func renderMetricValue(value string, bindings datalog.Bindings) string {
-	// Check if it's a function call like format_time(?Dur)
-	if strings.HasPrefix(value, "format_time(") {
-		varMatch := regexp.MustCompile(`format_time\((\?\w+)\)`).FindStringSubmatch(value)
-		if len(varMatch) > 1 {
-			varName := varMatch[1]
-			if val, ok := bindings[datalog.Variable(varName)]; ok {
-				if numVal, err := toFloat64(val); err == nil {
-					return datalog.FormatDuration(numVal)
-				}
-			}
+// metricCallRe matches a metric value that calls a function, e.g. format_time(?Dur)
+var metricCallRe = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+
+// renderMetricValue renders a metric value, calling the engine's builtin when
+// the value is a function call such as format_time(?Dur) or format_bytes(?Bytes)
+func (e *Evaluator) renderMetricValue(value string, bindings datalog.Bindings) string {
+	if match := metricCallRe.FindStringSubmatch(value); match != nil {
+		if result, ok := e.callBuiltin(match[1], match[2], bindings); ok {
+			return formatValue(result)
 		}
 	}
 
@@ -244,6 +300,44 @@ func renderMetricValue(value string, bindings datalog.Bindings) string {
 	return renderTemplate(value, bindings)
 }
 
+// callBuiltin calls builtin name with comma-separated arguments, each a bound
+// variable, a number or a quoted string; false when an argument cannot be
+// resolved or the call fails
+func (e *Evaluator) callBuiltin(name, argList string, bindings datalog.Bindings) (interface{}, bool) {
+	var args []interface{}
+	if strings.TrimSpace(argList) != "" {
+		for _, arg := range strings.Split(argList, ",") {
+			arg = strings.TrimSpace(arg)
+			switch {
+			case strings.HasPrefix(arg, "?"):
+				val, ok := bindings[datalog.Variable(arg)]
+				if !ok {
+					return nil, false
+				}
+				args = append(args, val)
+			case strings.HasPrefix(arg, `"`):
+				str, err := strconv.Unquote(arg)
+				if err != nil {
+					return nil, false
+				}
+				args = append(args, str)
+			default:
+				num, err := strconv.ParseFloat(arg, 64)
+				if err != nil {
+					return nil, false
+				}
+				args = append(args, num)
+			}
+		}
+	}
+
+	result, err := e.engine.CallBuiltin(name, args)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
 // formatValue formats a value for display
 func formatValue(val interface{}) string {
 	switch v := val.(type) {
@@ -256,6 +350,13 @@ func formatValue(val interface{}) string {
 		return fmt.Sprintf("%d", v)
 	case int:
 		return fmt.Sprintf("%d", v)
+	case []interface{}:
+		// Lists from collect aggregates
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ", ")
 	default:
 		return fmt.Sprint(v)
 	}
@@ -290,26 +391,3 @@ func deduplicateSuggestions(suggestions []datalog.Suggestion) []datalog.Suggesti
 
 	return result
 }
-
-func toFloat64(val interface{}) (float64, error) {
-	switch v := val.(type) {
-	case float64:
-		return v, nil
-	case float32:
-		return float64(v), nil
-	case int:
-		return float64(v), nil
-	case int64:
-		return float64(v), nil
-	case int32:
-		return float64(v), nil
-	case uint:
-		return float64(v), nil
-	case uint64:
-		return float64(v), nil
-	case uint32:
-		return float64(v), nil
-	default:
-		return 0, fmt.Errorf("cannot convert %T to float64", val)
-	}
-}